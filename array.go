@@ -0,0 +1,458 @@
+package wasmexec
+
+import (
+	"math"
+	"reflect"
+)
+
+// arrayGet returns the property name of an array, which is one of its elements
+// if name is an index. Other than that, an array has a length and methods, and
+// any property that was set on it.
+func (mod *Module) arrayGet(a *jsArray, name string) any {
+	if i, ok := arrayIndex(name); ok {
+		if int64(i) >= int64(len(a.elements)) {
+			return nil
+		}
+		return a.elements[i]
+	}
+
+	if v, ok := a.properties[name]; ok {
+		return v
+	}
+
+	switch name {
+	case "length":
+		return float64(len(a.elements))
+
+	case "at":
+		return &jsFunction{
+			fn: func(args []any) any {
+				f := toIntegerOrInfinity(arg(args, 0))
+				if f < 0 {
+					f += float64(len(a.elements))
+				}
+				if f < 0 || f >= float64(len(a.elements)) {
+					return nil
+				}
+				return a.elements[int(f)]
+			},
+		}
+
+	case "concat":
+		return &jsFunction{
+			fn: func(args []any) any {
+				elements := append([]any(nil), a.elements...)
+				for _, arg := range args {
+					if other, ok := normalize(arg).(*jsArray); ok {
+						if len(elements)+len(other.elements) > maxArrayLength {
+							return mod.invalidArrayLength()
+						}
+						elements = append(elements, other.elements...)
+					} else {
+						if len(elements)+1 > maxArrayLength {
+							return mod.invalidArrayLength()
+						}
+						elements = append(elements, arg)
+					}
+				}
+				return &jsArray{elements: elements}
+			},
+		}
+
+	case "includes":
+		return &jsFunction{
+			fn: func(args []any) any {
+				// Unlike indexOf, includes finds NaN.
+				search := normalize(arg(args, 0))
+				for _, element := range a.elements[startIndex(arg(args, 1), len(a.elements)):] {
+					if f, ok := search.(float64); ok && math.IsNaN(f) {
+						if g, ok := normalize(element).(float64); ok && math.IsNaN(g) {
+							return true
+						}
+					}
+					if strictEquals(element, search) {
+						return true
+					}
+				}
+				return false
+			},
+		}
+
+	case "indexOf":
+		return &jsFunction{
+			fn: func(args []any) any {
+				for i := startIndex(arg(args, 1), len(a.elements)); i < len(a.elements); i++ {
+					if strictEquals(a.elements[i], arg(args, 0)) {
+						return float64(i)
+					}
+				}
+				return float64(-1)
+			},
+		}
+
+	case "join":
+		return &jsFunction{
+			fn: func(args []any) any {
+				separator := ","
+				if v := arg(args, 0); v != nil {
+					separator = toString(v)
+				}
+				return joinArray(a, separator, nil)
+			},
+		}
+
+	case "lastIndexOf":
+		return &jsFunction{
+			fn: func(args []any) any {
+				from := len(a.elements) - 1
+				if v := arg(args, 1); v != nil {
+					f := toIntegerOrInfinity(v)
+					if f < 0 {
+						f += float64(len(a.elements))
+					}
+					from = int(math.Max(-1, math.Min(f, float64(len(a.elements)-1))))
+				}
+				for i := from; i >= 0; i-- {
+					if strictEquals(a.elements[i], arg(args, 0)) {
+						return float64(i)
+					}
+				}
+				return float64(-1)
+			},
+		}
+
+	case "pop":
+		return &jsFunction{
+			fn: func([]any) any {
+				if len(a.elements) == 0 {
+					return nil
+				}
+				last := a.elements[len(a.elements)-1]
+				a.elements[len(a.elements)-1] = nil
+				a.elements = a.elements[:len(a.elements)-1]
+				return last
+			},
+		}
+
+	case "push":
+		return &jsFunction{
+			fn: func(args []any) any {
+				if len(a.elements)+len(args) > maxArrayLength {
+					return mod.invalidArrayLength()
+				}
+				a.elements = append(a.elements, args...)
+				return float64(len(a.elements))
+			},
+		}
+
+	case "reverse":
+		return &jsFunction{
+			fn: func([]any) any {
+				for i, j := 0, len(a.elements)-1; i < j; i, j = i+1, j-1 {
+					a.elements[i], a.elements[j] = a.elements[j], a.elements[i]
+				}
+				return a
+			},
+		}
+
+	case "shift":
+		return &jsFunction{
+			fn: func([]any) any {
+				if len(a.elements) == 0 {
+					return nil
+				}
+				first := a.elements[0]
+				a.elements = append(a.elements[:0], a.elements[1:]...)
+				return first
+			},
+		}
+
+	case "slice":
+		return &jsFunction{
+			fn: func(args []any) any {
+				begin, end := relativeRange(args, len(a.elements))
+				return &jsArray{elements: append([]any(nil), a.elements[begin:end]...)}
+			},
+		}
+
+	case "splice":
+		return &jsFunction{
+			fn: func(args []any) any {
+				if len(args) == 0 {
+					return &jsArray{}
+				}
+
+				start, _ := relativeRange(args, len(a.elements))
+				count := len(a.elements) - start
+				if len(args) > 1 {
+					f := toIntegerOrInfinity(args[1])
+					count = int(math.Max(0, math.Min(f, float64(count))))
+				}
+
+				var rest []any
+				if len(args) > 2 {
+					rest = append(rest, args[2:]...)
+				}
+				if len(a.elements)-count+len(rest) > maxArrayLength {
+					return mod.invalidArrayLength()
+				}
+
+				removed := append([]any(nil), a.elements[start:start+count]...)
+				rest = append(rest, a.elements[start+count:]...)
+				a.elements = append(a.elements[:start], rest...)
+
+				return &jsArray{elements: removed}
+			},
+		}
+
+	case "unshift":
+		return &jsFunction{
+			fn: func(args []any) any {
+				if len(a.elements)+len(args) > maxArrayLength {
+					return mod.invalidArrayLength()
+				}
+				a.elements = append(append([]any(nil), args...), a.elements...)
+				return float64(len(a.elements))
+			},
+		}
+
+	case "toString":
+		return &jsFunction{
+			fn: func([]any) any {
+				return toString(a)
+			},
+		}
+
+	default:
+		return nil
+	}
+}
+
+// maxArrayLength is the maximum length of an array. JavaScript allows for far
+// longer arrays, as long as most of their elements are missing, but an array
+// here holds all its elements. Without a limit, setting a single element at a
+// large index would exhaust the memory of the host.
+const maxArrayLength = 1 << 24
+
+// setLength sets the length of an array, which removes the elements past the
+// new length, or adds undefined elements up to it. It returns a RangeError if
+// the length exceeds maxArrayLength.
+func (a *jsArray) setLength(length int) error {
+	if length < 0 || length > maxArrayLength {
+		return &Error{Name: "RangeError", Message: "Invalid array length"}
+	}
+
+	if length <= len(a.elements) {
+		for i := length; i < len(a.elements); i++ {
+			a.elements[i] = nil
+		}
+		a.elements = a.elements[:length]
+		return nil
+	}
+
+	a.elements = append(a.elements, make([]any, length-len(a.elements))...)
+	return nil
+}
+
+// invalidArrayLength throws the RangeError of a method that would grow an array
+// past maxArrayLength.
+func (mod *Module) invalidArrayLength() *jsThrow {
+	return &jsThrow{value: mod.newError("RangeError", "Invalid array length")}
+}
+
+// toIntegerOrInfinity converts v to an integer like JavaScript does for indices
+// and counts: fractions are truncated towards zero, and NaN becomes 0. The
+// result may be infinite, so callers clamp it before converting it to an int.
+func toIntegerOrInfinity(v any) float64 {
+	f := math.Trunc(toNumber(v))
+	if math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+// startIndex returns the index at which methods like indexOf start searching,
+// which counts from the end if it is negative.
+func startIndex(v any, length int) int {
+	f := toIntegerOrInfinity(v)
+	if f < 0 {
+		f += float64(length)
+	}
+
+	return int(math.Max(0, math.Min(f, float64(length))))
+}
+
+// strictEquals compares a and b like the === operator does. Objects are equal
+// only if they are the same object, and NaN is not equal to anything.
+func strictEquals(a, b any) bool {
+	a, b = normalize(a), normalize(b)
+
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case bool, float64, string:
+		return a == b
+	case jsProperties:
+		bv, ok := b.(jsProperties)
+		return ok && reflect.ValueOf(av).Pointer() == reflect.ValueOf(bv).Pointer()
+	}
+
+	if b == nil {
+		return false
+	}
+
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if ra.Type() != rb.Type() || !ra.Type().Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// objectGet returns the property name of an object, if it has it, or else
+// the method of the same name that all objects have.
+func objectGet(properties jsProperties, name string) any {
+	if v, ok := properties[name]; ok {
+		return v
+	}
+
+	switch name {
+	case "hasOwnProperty":
+		return &jsFunction{
+			fn: func(args []any) any {
+				_, ok := properties[toString(arg(args, 0))]
+				return ok
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// objectStatics returns the static methods of the Object constructor.
+func (mod *Module) objectStatics() jsProperties {
+	return jsProperties{
+		"assign": &jsFunction{
+			fn: func(args []any) any {
+				target := arg(args, 0)
+				if target == nil {
+					return &jsThrow{value: mod.newError("TypeError", "Cannot convert undefined or null to object")}
+				}
+
+				// Sources that are undefined or null are skipped.
+				for _, source := range args[1:] {
+					if source == nil {
+						continue
+					}
+
+					keys, values, err := mod.ownProperties(source)
+					if err != nil {
+						return &jsThrow{value: mod.errorObject(err)}
+					}
+					for i, key := range keys {
+						if err := mod.reflectSet(target, key, values[i]); err != nil {
+							return &jsThrow{value: mod.newError("TypeError", "Object.assign: "+err.Error())}
+						}
+					}
+				}
+				return target
+			},
+		},
+		"entries": &jsFunction{
+			fn: func(args []any) any {
+				keys, values, err := mod.ownProperties(arg(args, 0))
+				if err != nil {
+					return &jsThrow{value: mod.errorObject(err)}
+				}
+				entries := make([]any, len(keys))
+				for i, key := range keys {
+					entries[i] = &jsArray{elements: []any{key, values[i]}}
+				}
+				return &jsArray{elements: entries}
+			},
+		},
+		"keys": &jsFunction{
+			fn: func(args []any) any {
+				keys, _, err := mod.ownProperties(arg(args, 0))
+				if err != nil {
+					return &jsThrow{value: mod.errorObject(err)}
+				}
+				elements := make([]any, len(keys))
+				for i, key := range keys {
+					elements[i] = key
+				}
+				return &jsArray{elements: elements}
+			},
+		},
+		"values": &jsFunction{
+			fn: func(args []any) any {
+				_, values, err := mod.ownProperties(arg(args, 0))
+				if err != nil {
+					return &jsThrow{value: mod.errorObject(err)}
+				}
+				return &jsArray{elements: values}
+			},
+		},
+	}
+}
+
+// ownProperties returns the names and values of the properties of v, in the
+// order in which Object.keys lists them. Arrays and strings have a property
+// for every index.
+func (mod *Module) ownProperties(v any) ([]string, []any, error) {
+	var properties jsProperties
+
+	switch vv := normalize(v).(type) {
+	case nil:
+		return nil, nil, typeError("Cannot convert undefined or null to object")
+
+	case *jsObject:
+		properties = vv.properties
+	case jsProperties:
+		properties = vv
+	case *jsFunction:
+		properties = vv.properties
+
+	case *jsArray:
+		properties = make(jsProperties, len(vv.elements)+len(vv.properties))
+		for i, element := range vv.elements {
+			properties[formatNumber(float64(i))] = element
+		}
+		for key, value := range vv.properties {
+			properties[key] = value
+		}
+
+	case typedArray:
+		properties = make(jsProperties, typedArrayLength(vv))
+		for i := 0; i < typedArrayLength(vv); i++ {
+			properties[formatNumber(float64(i))], _ = typedArrayIndex(vv, i)
+		}
+
+	case string:
+		units := utf16Of(vv)
+		properties = make(jsProperties, len(units))
+		for i := range units {
+			properties[formatNumber(float64(i))] = stringOf(units[i : i+1])
+		}
+
+	case HostObject:
+		keys := vv.Keys()
+		values := make([]any, len(keys))
+		for i, key := range keys {
+			value, err := mod.hostObjectGet(vv, key)
+			if err != nil {
+				return nil, nil, err
+			}
+			values[i] = value
+		}
+		return keys, values, nil
+	}
+
+	keys := sortedKeys(properties)
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = properties[key]
+	}
+
+	return keys, values, nil
+}
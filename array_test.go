@@ -0,0 +1,177 @@
+package wasmexec
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestArraySet(t *testing.T) {
+	mod := newTestModule()
+
+	tests := []struct {
+		name  string
+		key   any
+		value any
+		want  string
+		err   string
+	}{
+		{name: "index", key: int64(1), value: "x", want: "a,x,c"},
+		{name: "append", key: int64(3), value: "d", want: "a,b,c,d"},
+		{name: "grow", key: int64(5), value: "f", want: "a,b,c,,,f"},
+		{name: "index by name", key: "0", value: "x", want: "x,b,c"},
+		{name: "property", key: "name", value: "x", want: "a,b,c"},
+		{name: "method", key: "push", value: "x", want: "a,b,c"},
+		{name: "shrink", key: "length", value: float64(1), want: "a"},
+		{name: "extend", key: "length", value: float64(4), want: "a,b,c,"},
+		{name: "index too large", key: int64(maxArrayLength), value: "x", err: "RangeError: Invalid array length"},
+		{name: "last index", key: int64(math.MaxInt32), value: "x", err: "RangeError: Invalid array length"},
+		{name: "length too large", key: "length", value: float64(math.MaxInt32), err: "RangeError: Invalid array length"},
+		{name: "invalid length", key: "length", value: float64(-1), err: "RangeError: Invalid array length"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &jsArray{elements: []any{"a", "b", "c"}}
+
+			err := mod.reflectSet(a, test.key, test.value)
+			if test.err != "" {
+				var e *Error
+				if !errors.As(err, &e) || e.Error() != test.err {
+					t.Fatalf("set: got %v, want %q", err, test.err)
+				}
+				if len(a.elements) != 3 {
+					t.Fatalf("set: the length changed to %d", len(a.elements))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("set: %v", err)
+			}
+			if toString(a) != test.want {
+				t.Fatalf("set: got %q, want %q", toString(a), test.want)
+			}
+		})
+	}
+}
+
+func TestArrayGet(t *testing.T) {
+	mod := newTestModule()
+
+	tests := []struct {
+		name string
+		key  any
+		want any
+	}{
+		{name: "index", key: int64(1), want: "b"},
+		{name: "index by name", key: "1", want: "b"},
+		{name: "last index by name", key: "2", want: "c"},
+		{name: "index past the end by name", key: "3", want: nil},
+		{name: "not an index", key: "01", want: nil},
+		{name: "length", key: "length", want: float64(3)},
+		{name: "property", key: "name", want: "array"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &jsArray{elements: []any{"a", "b", "c"}}
+			if err := mod.reflectSet(a, "name", "array"); err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := mod.reflectGet(a, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != test.want {
+				t.Fatalf("got %v, want %v", v, test.want)
+			}
+		})
+	}
+
+	// A property that shadows a method is read instead of it, and deleting it
+	// uncovers the method again.
+	a := &jsArray{elements: []any{"a"}}
+	if err := mod.reflectSet(a, "push", "shadowed"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mod.reflectGet(a, "push"); v != "shadowed" {
+		t.Errorf("push: got %v, want the property", v)
+	}
+	if err := mod.reflectDeleteProperty(a, "push"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mod.reflectGet(a, "push"); v == "shadowed" {
+		t.Error("push: got the deleted property, want the method")
+	}
+
+	// Deleting an element by name leaves a hole.
+	if err := mod.reflectDeleteProperty(a, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.elements) != 1 || a.elements[0] != nil {
+		t.Errorf("delete: got %v, want a hole", a.elements)
+	}
+}
+
+func TestArrayMethods(t *testing.T) {
+	mod := newTestModule()
+	long := &jsArray{elements: make([]any, maxArrayLength)}
+
+	tests := []struct {
+		name   string
+		method string
+		args   []any
+		want   any
+		array  string
+		err    string
+	}{
+		{name: "at", method: "at", args: []any{float64(-1)}, want: "c"},
+		{name: "at NaN", method: "at", args: []any{math.NaN()}, want: "a"},
+		{name: "at fraction", method: "at", args: []any{1.5}, want: "b"},
+		{name: "at infinity", method: "at", args: []any{math.Inf(1)}, want: nil},
+		{name: "at negative infinity", method: "at", args: []any{math.Inf(-1)}, want: nil},
+		{name: "lastIndexOf", method: "lastIndexOf", args: []any{"a"}, want: float64(0)},
+		{name: "lastIndexOf NaN", method: "lastIndexOf", args: []any{"a", math.NaN()}, want: float64(0)},
+		{name: "lastIndexOf infinity", method: "lastIndexOf", args: []any{"c", math.Inf(1)}, want: float64(2)},
+		{name: "lastIndexOf negative infinity", method: "lastIndexOf", args: []any{"a", math.Inf(-1)}, want: float64(-1)},
+		{name: "splice NaN", method: "splice", args: []any{float64(1), math.NaN()}, array: "a,b,c"},
+		{name: "splice infinity", method: "splice", args: []any{float64(1), math.Inf(1)}, array: "a"},
+		{name: "push", method: "push", args: []any{"d"}, want: float64(4), array: "a,b,c,d"},
+		{name: "push too many", method: "push", args: long.elements, err: "RangeError: Invalid array length"},
+		{name: "unshift too many", method: "unshift", args: long.elements, err: "RangeError: Invalid array length"},
+		{name: "splice too many", method: "splice", args: append([]any{float64(0), float64(0)}, long.elements...), err: "RangeError: Invalid array length"},
+		{name: "concat too many", method: "concat", args: []any{long}, err: "RangeError: Invalid array length"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &jsArray{elements: []any{"a", "b", "c"}}
+			fn := mod.arrayGet(a, test.method).(*jsFunction)
+
+			v := fn.fn(test.args)
+			if test.err != "" {
+				thrown, ok := v.(*jsThrow)
+				if !ok {
+					t.Fatalf("%s: got %v, want %q", test.method, v, test.err)
+				}
+				e := thrown.value.(*jsObject).properties
+				if got := toString(e["name"]) + ": " + toString(e["message"]); got != test.err {
+					t.Fatalf("%s: got %q, want %q", test.method, got, test.err)
+				}
+				if len(a.elements) != 3 {
+					t.Fatalf("%s: the length changed to %d", test.method, len(a.elements))
+				}
+				return
+			}
+
+			if test.method != "splice" && v != test.want {
+				t.Errorf("%s: got %v, want %v", test.method, v, test.want)
+			}
+			if test.array != "" && toString(a) != test.array {
+				t.Errorf("%s: got %q, want %q", test.method, toString(a), test.array)
+			}
+		})
+	}
+}
@@ -0,0 +1,648 @@
+package wasmexec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	valueType   = reflect.TypeOf(Value{})
+)
+
+// Bind makes the Go value v available to the guest as the property name of
+// the global object, converting it to a JavaScript value with reflection:
+//
+//   - Booleans, numbers and strings become their JavaScript counterparts.
+//   - A []byte becomes a Uint8Array with a copy of its contents.
+//   - Other slices and arrays become arrays.
+//   - Maps become objects, with their keys formatted as strings.
+//   - Structs and pointers to structs become objects. Their exported fields
+//     become properties, holding a copy of the value of the field at the time
+//     of the conversion, and their exported methods become functions.
+//   - An error becomes an Error object, or an object of the error type named
+//     by an *Error.
+//   - A *Promise stays the same promise.
+//   - Functions become functions.
+//   - A nil pointer, slice, map, function or interface becomes null.
+//
+// Properties and functions keep the names of their Go fields and methods.
+//
+// When the guest calls a function, its arguments are converted to the types
+// of the parameters of the Go function, following the same rules in reverse.
+// A parameter of type Value receives the argument as is, and a parameter of
+// type any receives a bool, float64, string, []byte, []any or map[string]any.
+// A function passed by the guest can only be called until the Go function
+// returns. Parameters of type context.Context are not taken from the
+// arguments, but receive a context that is cancelled when the guest stops.
+//
+// If the last result of a function is an error, a non-nil error is thrown in
+// the guest. Otherwise, a single result is returned as is and multiple results
+// are returned as an array. A panic in a function is thrown in the guest as an
+// Error as well.
+//
+// Pointers to structs may form cycles, which become cycles of objects. A map
+// or slice that holds itself, or an object or array of the guest that holds
+// itself, can not be converted and results in a TypeError.
+//
+// Bind must not be called from within a function called by the guest, as that
+// would deadlock.
+func (mod *Module) Bind(name string, v any) error {
+	return mod.SetGlobal(name, v)
+}
+
+// errCircular is returned for a value that holds itself, which can not be
+// converted.
+var errCircular = &Error{Name: "TypeError", Message: "Converting circular structure"}
+
+// visitKey identifies a map, slice or pointer that is being converted. A slice
+// is identified by its length as well, as a shorter slice of the same array
+// is a different value.
+type visitKey struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// visiting holds the maps, slices and pointers that are being converted, to
+// detect values that hold themselves.
+type visiting map[visitKey]bool
+
+// enter marks rv as being converted, and returns a function that unmarks it.
+// It returns errCircular if rv is being converted already, which means that it
+// holds itself. Values other than maps, slices and pointers are not marked.
+func (visited visiting) enter(rv reflect.Value) (func(), error) {
+	key := visitKey{typ: rv.Type()}
+	switch rv.Kind() {
+	case reflect.Slice:
+		key.len = rv.Len()
+	case reflect.Map, reflect.Pointer:
+	default:
+		return func() {}, nil
+	}
+
+	key.ptr = rv.Pointer()
+	if visited[key] {
+		return nil, errCircular
+	}
+	visited[key] = true
+
+	return func() { delete(visited, key) }, nil
+}
+
+// conversion holds the state of a conversion of a Go value to a value that
+// can be stored in the guest.
+type conversion struct {
+	// objects holds the objects that were created for pointers to structs,
+	// so that a pointer refers to the same object wherever it appears.
+	objects map[uintptr]*jsObject
+
+	// visited holds the maps and slices that are being converted.
+	visited visiting
+}
+
+// newConversion returns the state of a new conversion.
+func newConversion() *conversion {
+	return &conversion{
+		objects: make(map[uintptr]*jsObject),
+		visited: make(visiting),
+	}
+}
+
+// reflectToJS converts a Go value to a value that can be stored in the guest.
+// Pointers to structs that were seen before refer to the same object, while a
+// map or slice that holds itself results in a TypeError, so that cyclic data
+// structures do not lead to infinite recursion.
+func (mod *Module) reflectToJS(rv reflect.Value, seen *conversion) (any, error) {
+	if !rv.IsValid() {
+		return nil, nil
+	}
+
+	if rv.Type() == valueType {
+		return rv.Interface().(Value).v, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Pointer, reflect.Slice, reflect.Map, reflect.Func:
+		if rv.IsNil() {
+			return nil, nil
+		}
+	}
+
+	if rv.Type().Implements(hostObjectType) || rv.Type() == promiseType {
+		return rv.Interface(), nil
+	}
+
+	if rv.Type().Implements(errorType) {
+		return mod.errorObject(rv.Interface().(error)), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+
+	case reflect.String:
+		return rv.String(), nil
+
+	case reflect.Interface:
+		return mod.reflectToJS(rv.Elem(), seen)
+
+	case reflect.Pointer:
+		if rv.Elem().Kind() == reflect.Struct {
+			return mod.reflectObject(rv, seen)
+		}
+
+		return mod.reflectToJS(rv.Elem(), seen)
+
+	case reflect.Struct:
+		return mod.reflectObject(rv, seen)
+
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(data), rv)
+			return &jsUint8Array{data: data}, nil
+		}
+
+		if rv.Kind() == reflect.Slice {
+			leave, err := seen.visited.enter(rv)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+
+		elements := make([]any, rv.Len())
+		for i := range elements {
+			var err error
+			if elements[i], err = mod.reflectToJS(rv.Index(i), seen); err != nil {
+				return nil, err
+			}
+		}
+
+		return &jsArray{elements: elements}, nil
+
+	case reflect.Map:
+		leave, err := seen.visited.enter(rv)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
+		properties := make(jsProperties, rv.Len())
+		for iter := rv.MapRange(); iter.Next(); {
+			value, err := mod.reflectToJS(iter.Value(), seen)
+			if err != nil {
+				return nil, err
+			}
+
+			properties[propertyName(iter.Key())] = value
+		}
+
+		return &jsObject{properties: properties}, nil
+
+	case reflect.Func:
+		return mod.reflectFunction(rv), nil
+
+	default:
+		return nil, fmt.Errorf("%s: unsupported type", rv.Type())
+	}
+}
+
+// propertyName returns the name of the property for a key of a Go map. Like
+// in JavaScript, a number key is named after its string form.
+func propertyName(key reflect.Value) string {
+	switch key.Kind() {
+	case reflect.String:
+		return key.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return formatNumber(float64(key.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return formatNumber(float64(key.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return formatNumber(key.Float())
+	default:
+		return fmt.Sprint(key.Interface())
+	}
+}
+
+// reflectObject converts a struct, or a pointer to a struct, to an object with
+// its exported fields as properties and its exported methods as functions.
+func (mod *Module) reflectObject(rv reflect.Value, seen *conversion) (*jsObject, error) {
+	if rv.Kind() == reflect.Pointer {
+		if obj, ok := seen.objects[rv.Pointer()]; ok {
+			return obj, nil
+		}
+	}
+
+	obj := &jsObject{properties: make(jsProperties)}
+	if rv.Kind() == reflect.Pointer {
+		seen.objects[rv.Pointer()] = obj
+	}
+
+	s := reflect.Indirect(rv)
+	for _, field := range reflect.VisibleFields(s.Type()) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+
+		// A promoted field of an embedded nil pointer is skipped.
+		fv, err := s.FieldByIndexErr(field.Index)
+		if err != nil {
+			continue
+		}
+
+		if obj.properties[field.Name], err = mod.reflectToJS(fv, seen); err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Name, err)
+		}
+	}
+
+	for i := 0; i < rv.NumMethod(); i++ {
+		obj.properties[rv.Type().Method(i).Name] = mod.reflectFunction(rv.Method(i))
+	}
+
+	return obj, nil
+}
+
+// reflectFunction returns a function that calls the Go function fn with the
+// arguments of the guest.
+func (mod *Module) reflectFunction(fn reflect.Value) *jsFunction {
+	return &jsFunction{
+		fn: func(args []any) any {
+			result, err := mod.reflectCall(fn, args)
+			if err != nil {
+				return &jsThrow{value: mod.errorObject(err)}
+			}
+
+			return result
+		},
+	}
+}
+
+// reflectCall calls the Go function fn with the arguments of the guest and
+// returns its results.
+func (mod *Module) reflectCall(fn reflect.Value, args []any) (any, error) {
+	t := fn.Type()
+
+	in := make([]reflect.Value, 0, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		typ := t.In(i)
+
+		switch {
+		case typ == contextType:
+			in = append(in, reflect.ValueOf(mod.ctx))
+			continue
+
+		case t.IsVariadic() && i == t.NumIn()-1:
+			for _, arg := range args {
+				v, err := mod.reflectFromJS(arg, typ.Elem(), make(visiting))
+				if err != nil {
+					return nil, fmt.Errorf("argument %d: %w", len(in), err)
+				}
+
+				in = append(in, v)
+			}
+
+			continue
+		}
+
+		// Missing arguments are undefined.
+		var arg any
+		if len(args) > 0 {
+			arg, args = args[0], args[1:]
+		}
+
+		v, err := mod.reflectFromJS(arg, typ, make(visiting))
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", len(in), err)
+		}
+
+		in = append(in, v)
+	}
+
+	out, err := callGo(fn, in)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, err
+		}
+
+		out = out[:n-1]
+	}
+
+	seen := newConversion()
+
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return mod.reflectToJS(out[0], seen)
+	}
+
+	results := make([]any, len(out))
+	for i := range out {
+		var err error
+		if results[i], err = mod.reflectToJS(out[i], seen); err != nil {
+			return nil, err
+		}
+	}
+
+	return &jsArray{elements: results}, nil
+}
+
+// callGo calls the Go function fn. A panic is returned as an error rather than
+// passed on, as it would unwind through the guest that called fn.
+func callGo(fn reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &Error{Name: "Error", Message: fmt.Sprint("panic: ", r)}
+		}
+	}()
+
+	return fn.Call(in), nil
+}
+
+// reflectFromJS converts a value of the guest to a Go value of type t. The
+// objects and arrays in visited are being converted already, and one that is
+// visited again results in a TypeError.
+func (mod *Module) reflectFromJS(v any, t reflect.Type, visited visiting) (reflect.Value, error) {
+	jsv := mod.value(v)
+	if t == valueType {
+		return reflect.ValueOf(jsv), nil
+	}
+
+	v = jsv.v
+	rv := reflect.New(t).Elem()
+
+	// Null and undefined convert to the zero value of any type.
+	if v == nil {
+		return rv, nil
+	}
+
+	mismatch := fmt.Errorf("%s: can not convert to %s", jsv.Type(), t)
+
+	if t == promiseType {
+		p, ok := v.(*Promise)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.Set(reflect.ValueOf(p))
+		return rv, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, ok := v.(float64)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.SetInt(int64(f))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f, ok := v.(float64)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.SetUint(uint64(f))
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := v.(float64)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.SetFloat(f)
+
+	case reflect.String:
+		str, ok := v.(string)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.SetString(str)
+
+	case reflect.Interface:
+		gov, err := mod.goValue(v, visited)
+		if err != nil {
+			return rv, err
+		}
+
+		gv := reflect.ValueOf(gov)
+		if !gv.Type().AssignableTo(t) {
+			return rv, mismatch
+		}
+		rv.Set(gv)
+
+	case reflect.Pointer:
+		elem, err := mod.reflectFromJS(v, t.Elem(), visited)
+		if err != nil {
+			return rv, err
+		}
+		rv.Set(reflect.New(t.Elem()))
+		rv.Elem().Set(elem)
+
+	case reflect.Slice, reflect.Array:
+		var elements []any
+		switch vv := v.(type) {
+		case typedArray:
+			elements = make([]any, typedArrayLength(vv))
+			for i := range elements {
+				elements[i], _ = typedArrayIndex(vv, i)
+			}
+		case *jsArray:
+			elements = vv.elements
+		default:
+			return rv, mismatch
+		}
+
+		leave, err := visited.enter(reflect.ValueOf(v))
+		if err != nil {
+			return rv, err
+		}
+		defer leave()
+
+		if t.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(t, len(elements), len(elements)))
+		} else if len(elements) > t.Len() {
+			elements = elements[:t.Len()]
+		}
+
+		for i, element := range elements {
+			ev, err := mod.reflectFromJS(element, t.Elem(), visited)
+			if err != nil {
+				return rv, fmt.Errorf("index %d: %w", i, err)
+			}
+			rv.Index(i).Set(ev)
+		}
+
+	case reflect.Map:
+		properties, ok := mod.objectProperties(v)
+		if !ok || t.Key().Kind() != reflect.String {
+			return rv, mismatch
+		}
+
+		leave, err := visited.enter(reflect.ValueOf(v))
+		if err != nil {
+			return rv, err
+		}
+		defer leave()
+
+		rv.Set(reflect.MakeMapWithSize(t, len(properties)))
+		for key, value := range properties {
+			ev, err := mod.reflectFromJS(value, t.Elem(), visited)
+			if err != nil {
+				return rv, fmt.Errorf("%s: %w", key, err)
+			}
+			rv.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), ev)
+		}
+
+	case reflect.Struct:
+		properties, ok := mod.objectProperties(v)
+		if !ok {
+			return rv, mismatch
+		}
+
+		leave, err := visited.enter(reflect.ValueOf(v))
+		if err != nil {
+			return rv, err
+		}
+		defer leave()
+
+		for key, value := range properties {
+			field, ok := t.FieldByName(key)
+			if !ok || !field.IsExported() {
+				continue
+			}
+
+			fv, err := rv.FieldByIndexErr(field.Index)
+			if err != nil {
+				continue
+			}
+
+			ev, err := mod.reflectFromJS(value, field.Type, visited)
+			if err != nil {
+				return rv, fmt.Errorf("%s: %w", key, err)
+			}
+			fv.Set(ev)
+		}
+
+	case reflect.Func:
+		fn, ok := v.(*jsFunction)
+		if !ok {
+			return rv, mismatch
+		}
+		rv.Set(mod.reflectGuestFunction(fn, t))
+
+	default:
+		return rv, fmt.Errorf("%s: unsupported type", t)
+	}
+
+	return rv, nil
+}
+
+// reflectGuestFunction returns a Go function of type t that calls the function
+// fn of the guest. If the last result of t is an error, it receives an *Error
+// for any exception thrown by fn. The first other result, if any, receives the return value.
+func (mod *Module) reflectGuestFunction(fn *jsFunction, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.New(t.Out(i)).Elem()
+		}
+
+		var err error
+		defer func() {
+			if n := len(out); n > 0 && t.Out(n-1) == errorType && err != nil {
+				out[n-1].Set(reflect.ValueOf(&err).Elem())
+			}
+		}()
+
+		seen := newConversion()
+		args := make([]any, len(in))
+		for i := range in {
+			if args[i], err = mod.reflectToJS(in[i], seen); err != nil {
+				return out
+			}
+		}
+
+		result := fn.fn(args)
+		if thrown, ok := result.(*jsThrow); ok {
+			err = mod.exception(thrown.value)
+			return out
+		}
+
+		if len(out) > 0 && t.Out(0) != errorType {
+			var v reflect.Value
+			if v, err = mod.reflectFromJS(result, t.Out(0), make(visiting)); err == nil {
+				out[0] = v
+			}
+		}
+
+		return out
+	})
+}
+
+// goValue converts a value of the guest to the Go value that it naturally
+// corresponds to. Like reflectFromJS, it returns a TypeError for an object or
+// array that holds itself.
+func (mod *Module) goValue(v any, visited visiting) (any, error) {
+	switch vv := normalize(v).(type) {
+	case *jsArray:
+		leave, err := visited.enter(reflect.ValueOf(vv))
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
+		elements := make([]any, len(vv.elements))
+		for i, element := range vv.elements {
+			if elements[i], err = mod.goValue(element, visited); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+
+	case *jsObject:
+		leave, err := visited.enter(reflect.ValueOf(vv))
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
+		properties := make(map[string]any, len(vv.properties))
+		for key, value := range vv.properties {
+			if properties[key], err = mod.goValue(value, visited); err != nil {
+				return nil, err
+			}
+		}
+		return properties, nil
+
+	case *jsUint8Array:
+		return append([]byte(nil), vv.data...), nil
+
+	case nil, bool, float64, string, HostObject:
+		return vv, nil
+
+	default:
+		return Value{mod: mod, v: vv}, nil
+	}
+}
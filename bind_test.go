@@ -0,0 +1,146 @@
+package wasmexec
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBindCycles(t *testing.T) {
+	mod := newTestModule()
+
+	type node struct {
+		Name string
+		Next *node
+	}
+
+	cyclicMap := map[string]any{"name": "map"}
+	cyclicMap["self"] = cyclicMap
+
+	cyclicSlice := []any{"slice", nil}
+	cyclicSlice[1] = cyclicSlice
+
+	nested := map[string][]any{"list": {nil}}
+	nested["list"][0] = nested
+
+	shared := []int{1, 2}
+	ring := &node{Name: "a"}
+	ring.Next = &node{Name: "b", Next: ring}
+
+	prefix := []any{"a", nil}
+	prefix[1] = prefix[:1]
+
+	tests := []struct {
+		name     string
+		value    any
+		circular bool
+	}{
+		{name: "map that holds itself", value: cyclicMap, circular: true},
+		{name: "slice that holds itself", value: cyclicSlice, circular: true},
+		{name: "map and slice that hold each other", value: nested, circular: true},
+		{name: "shared slice", value: map[string][]int{"a": shared, "b": shared}},
+		{name: "shorter slice of the same array", value: prefix},
+		{name: "ring of pointers", value: ring},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := mod.toJS(test.value)
+			switch {
+			case test.circular && !errors.Is(err, errCircular):
+				t.Fatalf("toJS: got %v, want %v", err, errCircular)
+			case !test.circular && err != nil:
+				t.Fatalf("toJS: %v", err)
+			}
+		})
+	}
+
+	// Pointers to structs become the same object.
+	v, err := mod.toJS(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := v.(*jsObject)
+	if next := obj.properties["Next"].(*jsObject); next.properties["Next"] != obj {
+		t.Error("toJS: a ring of pointers did not become a ring of objects")
+	}
+}
+
+func TestBindGuestCycles(t *testing.T) {
+	mod := newTestModule()
+
+	cyclicObject := &jsObject{properties: jsProperties{"name": "object"}}
+	cyclicObject.properties["self"] = cyclicObject
+
+	cyclicStruct := &jsObject{properties: jsProperties{"Name": "struct"}}
+	cyclicStruct.properties["Self"] = cyclicStruct
+
+	cyclicArray := &jsArray{elements: []any{"array"}}
+	cyclicArray.elements = append(cyclicArray.elements, cyclicArray)
+
+	sharedObject := &jsObject{properties: jsProperties{"name": "shared"}}
+	shared := &jsArray{elements: []any{sharedObject, sharedObject}}
+
+	type node struct {
+		Name string
+		Self *node
+	}
+
+	functions := map[string]any{
+		"any":    func(v any) {},
+		"map":    func(v map[string]any) {},
+		"struct": func(v node) {},
+		"slice":  func(v []any) {},
+	}
+
+	tests := []struct {
+		name     string
+		fn       string
+		value    any
+		circular bool
+	}{
+		{name: "object as any", fn: "any", value: cyclicObject, circular: true},
+		{name: "object as map", fn: "map", value: cyclicObject, circular: true},
+		{name: "object as struct", fn: "struct", value: cyclicStruct, circular: true},
+		{name: "array as any", fn: "any", value: cyclicArray, circular: true},
+		{name: "array as slice", fn: "slice", value: cyclicArray, circular: true},
+		{name: "shared object as any", fn: "any", value: shared},
+		{name: "shared object as slice", fn: "slice", value: shared},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn, err := mod.toJS(functions[test.fn])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := fn.(*jsFunction).fn([]any{test.value})
+			thrown, ok := result.(*jsThrow)
+
+			switch {
+			case test.circular && !ok:
+				t.Fatalf("got %v, want a TypeError", result)
+			case test.circular && toString(thrown.value) != "TypeError: Converting circular structure":
+				t.Fatalf("got %q, want a TypeError", toString(thrown.value))
+			case !test.circular && ok:
+				t.Fatalf("got %q", toString(thrown.value))
+			}
+		})
+	}
+}
+
+func TestBindPanic(t *testing.T) {
+	mod := newTestModule()
+
+	fn, err := mod.toJS(func() int {
+		panic("broken")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thrown, ok := fn.(*jsFunction).fn(nil).(*jsThrow)
+	if want := "Error: panic: broken"; !ok || toString(thrown.value) != want {
+		t.Fatalf("got %v, want %q", thrown, want)
+	}
+}
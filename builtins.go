@@ -0,0 +1,447 @@
+package wasmexec
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// builtins returns the built-in values of the global object that are not
+// constructors of objects, like Math and JSON.
+func (mod *Module) builtins() jsProperties {
+	global := mod.global()
+	timeOrigin := mod.clock.Nanotime()
+
+	return jsProperties{
+		"globalThis": global,
+		"self":       global,
+
+		"NaN":      NaN,
+		"Infinity": math.Inf(1),
+
+		"isNaN": numberFunction(func(f float64) any {
+			return math.IsNaN(f)
+		}),
+		"isFinite": numberFunction(func(f float64) any {
+			return !math.IsNaN(f) && !math.IsInf(f, 0)
+		}),
+		"parseFloat": &jsFunction{
+			name: "parseFloat",
+			fn: func(args []any) any {
+				return parseFloat(toString(arg(args, 0)))
+			},
+		},
+		"parseInt": &jsFunction{
+			name: "parseInt",
+			fn: func(args []any) any {
+				return parseInt(toString(arg(args, 0)), toNumber(arg(args, 1)))
+			},
+		},
+
+		"Boolean": &jsFunction{
+			name: "Boolean",
+			fn: func(args []any) any {
+				return toBoolean(arg(args, 0))
+			},
+		},
+		"Number": &jsFunction{
+			name: "Number",
+			fn: func(args []any) any {
+				if len(args) == 0 {
+					return float64(0)
+				}
+				return toNumber(args[0])
+			},
+			properties: numberStatics(),
+		},
+		"String": &jsFunction{
+			name: "String",
+			fn: func(args []any) any {
+				if len(args) == 0 {
+					return ""
+				}
+				return toString(args[0])
+			},
+			properties: mod.stringStatics(),
+		},
+
+		"JSON": mod.jsonObject(),
+		"Math": mod.mathObject(),
+
+		"performance": &jsObject{
+			properties: jsProperties{
+				"timeOrigin": float64(timeOrigin) / 1e6,
+				"now": &jsFunction{
+					name: "now",
+					fn: func([]any) any {
+						return float64(mod.clock.Nanotime()-timeOrigin) / 1e6
+					},
+				},
+			},
+		},
+	}
+}
+
+// numberFunction returns a function that calls fn with its first argument
+// converted to a number.
+func numberFunction(fn func(f float64) any) *jsFunction {
+	return &jsFunction{
+		fn: func(args []any) any {
+			return fn(toNumber(arg(args, 0)))
+		},
+	}
+}
+
+// mathFunction returns a function that calls fn with its first argument
+// converted to a number and returns the result.
+func mathFunction(fn func(f float64) float64) *jsFunction {
+	return numberFunction(func(f float64) any {
+		return fn(f)
+	})
+}
+
+// mathFunction2 is like mathFunction, for functions with two arguments.
+func mathFunction2(fn func(x, y float64) float64) *jsFunction {
+	return &jsFunction{
+		fn: func(args []any) any {
+			return fn(toNumber(arg(args, 0)), toNumber(arg(args, 1)))
+		},
+	}
+}
+
+// numbers converts all arguments to numbers.
+func numbers(args []any) []float64 {
+	nums := make([]float64, len(args))
+	for i, arg := range args {
+		nums[i] = toNumber(arg)
+	}
+
+	return nums
+}
+
+// mathObject returns the Math object.
+func (mod *Module) mathObject() *jsObject {
+	return &jsObject{
+		properties: jsProperties{
+			"E":       math.E,
+			"LN10":    math.Ln10,
+			"LN2":     math.Ln2,
+			"LOG10E":  math.Log10E,
+			"LOG2E":   math.Log2E,
+			"PI":      math.Pi,
+			"SQRT1_2": math.Sqrt2 / 2,
+			"SQRT2":   math.Sqrt2,
+
+			"abs":   mathFunction(math.Abs),
+			"acos":  mathFunction(math.Acos),
+			"acosh": mathFunction(math.Acosh),
+			"asin":  mathFunction(math.Asin),
+			"asinh": mathFunction(math.Asinh),
+			"atan":  mathFunction(math.Atan),
+			"atanh": mathFunction(math.Atanh),
+			"atan2": mathFunction2(math.Atan2),
+			"cbrt":  mathFunction(math.Cbrt),
+			"ceil":  mathFunction(math.Ceil),
+			"clz32": mathFunction(func(f float64) float64 {
+				return float64(bits.LeadingZeros32(toUint32(f)))
+			}),
+			"cos":   mathFunction(math.Cos),
+			"cosh":  mathFunction(math.Cosh),
+			"exp":   mathFunction(math.Exp),
+			"expm1": mathFunction(math.Expm1),
+			"floor": mathFunction(math.Floor),
+			"fround": mathFunction(func(f float64) float64 {
+				return float64(float32(f))
+			}),
+			"hypot": &jsFunction{
+				fn: func(args []any) any {
+					// Infinity wins over NaN, unlike in a sum of squares.
+					var sum float64
+					var nan bool
+					for _, f := range numbers(args) {
+						switch {
+						case math.IsInf(f, 0):
+							return math.Inf(1)
+						case math.IsNaN(f):
+							nan = true
+						}
+						sum = math.Hypot(sum, f)
+					}
+					if nan {
+						return NaN
+					}
+					return sum
+				},
+			},
+			"imul": mathFunction2(func(x, y float64) float64 {
+				return float64(int32(toUint32(x) * toUint32(y)))
+			}),
+			"log":   mathFunction(math.Log),
+			"log1p": mathFunction(math.Log1p),
+			"log10": mathFunction(math.Log10),
+			"log2":  mathFunction(math.Log2),
+			"max": &jsFunction{
+				fn: func(args []any) any {
+					max := math.Inf(-1)
+					for _, f := range numbers(args) {
+						max = math.Max(max, f)
+					}
+					return max
+				},
+			},
+			"min": &jsFunction{
+				fn: func(args []any) any {
+					min := math.Inf(1)
+					for _, f := range numbers(args) {
+						min = math.Min(min, f)
+					}
+					return min
+				},
+			},
+			"pow": mathFunction2(pow),
+			"random": &jsFunction{
+				fn: func([]any) any {
+					var b [8]byte
+					if _, err := io.ReadFull(mod.entropy, b[:]); err != nil {
+						return &jsThrow{value: mod.newError("Error", "Math.random: "+err.Error())}
+					}
+
+					// Use the 53 bits that a float64 can hold.
+					return float64(binary.LittleEndian.Uint64(b[:])>>11) / (1 << 53)
+				},
+			},
+			"round": mathFunction(round),
+			"sign": mathFunction(func(f float64) float64 {
+				switch {
+				case f > 0:
+					return 1
+				case f < 0:
+					return -1
+				default:
+					return f
+				}
+			}),
+			"sin":   mathFunction(math.Sin),
+			"sinh":  mathFunction(math.Sinh),
+			"sqrt":  mathFunction(math.Sqrt),
+			"tan":   mathFunction(math.Tan),
+			"tanh":  mathFunction(math.Tanh),
+			"trunc": mathFunction(math.Trunc),
+		},
+	}
+}
+
+// pow returns x**y like JavaScript, which differs from math.Pow in that 1 to
+// the power of NaN or infinity is NaN.
+func pow(x, y float64) float64 {
+	if math.IsNaN(y) || (math.Abs(x) == 1 && math.IsInf(y, 0)) {
+		return NaN
+	}
+
+	return math.Pow(x, y)
+}
+
+// round rounds f to the nearest integer like Math.round, which rounds halves
+// up rather than away from zero.
+func round(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) || f == 0 {
+		return f
+	}
+
+	r := math.Floor(f)
+	if f-r >= 0.5 {
+		r++
+	}
+
+	// A negative number that rounds to zero rounds to -0.
+	if r == 0 && f < 0 {
+		return math.Copysign(0, -1)
+	}
+
+	return r
+}
+
+// numberStatics returns the properties of the Number function.
+func numberStatics() jsProperties {
+	return jsProperties{
+		"EPSILON":           math.Nextafter(1, 2) - 1,
+		"MAX_SAFE_INTEGER":  float64(1<<53 - 1),
+		"MIN_SAFE_INTEGER":  -float64(1<<53 - 1),
+		"MAX_VALUE":         math.MaxFloat64,
+		"MIN_VALUE":         math.SmallestNonzeroFloat64,
+		"NaN":               NaN,
+		"NEGATIVE_INFINITY": math.Inf(-1),
+		"POSITIVE_INFINITY": math.Inf(1),
+
+		// Unlike the global functions, these do not convert their argument.
+		"isFinite": &jsFunction{
+			fn: func(args []any) any {
+				f, ok := normalize(arg(args, 0)).(float64)
+				return ok && !math.IsNaN(f) && !math.IsInf(f, 0)
+			},
+		},
+		"isInteger": &jsFunction{
+			fn: func(args []any) any {
+				f, ok := normalize(arg(args, 0)).(float64)
+				return ok && !math.IsInf(f, 0) && f == math.Trunc(f)
+			},
+		},
+		"isNaN": &jsFunction{
+			fn: func(args []any) any {
+				f, ok := normalize(arg(args, 0)).(float64)
+				return ok && math.IsNaN(f)
+			},
+		},
+		"isSafeInteger": &jsFunction{
+			fn: func(args []any) any {
+				f, ok := normalize(arg(args, 0)).(float64)
+				return ok && f == math.Trunc(f) && math.Abs(f) <= 1<<53-1
+			},
+		},
+		"parseFloat": &jsFunction{
+			fn: func(args []any) any {
+				return parseFloat(toString(arg(args, 0)))
+			},
+		},
+		"parseInt": &jsFunction{
+			fn: func(args []any) any {
+				return parseInt(toString(arg(args, 0)), toNumber(arg(args, 1)))
+			},
+		},
+	}
+}
+
+// parseFloat parses the longest prefix of s that is a decimal number, like
+// parseFloat in JavaScript.
+func parseFloat(s string) float64 {
+	s = strings.TrimLeftFunc(s, isSpace)
+
+	// Find the longest prefix that is a number, starting with the sign.
+	end := 0
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	if strings.HasPrefix(s[end:], "Infinity") {
+		return parseNumber(s[:end+len("Infinity")])
+	}
+
+	digits := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+		digits++
+	}
+	if end < len(s) && s[end] == '.' {
+		end++
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return NaN
+	}
+
+	// An exponent only counts if it has digits.
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		i := end + 1
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			end = i
+		}
+	}
+
+	f, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil && !isRangeError(err) {
+		return NaN
+	}
+
+	return f
+}
+
+// parseInt parses the longest prefix of s that is an integer in the specified
+// radix, like parseInt in JavaScript. A radix of 0 means 10, or 16 if s starts
+// with "0x".
+func parseInt(s string, radix float64) float64 {
+	s = strings.TrimLeftFunc(s, isSpace)
+
+	sign := 1.0
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+
+	base := int(toInt32(radix))
+	switch {
+	case base == 0 || base == 16:
+		if len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+			s = s[2:]
+			base = 16
+		} else if base == 0 {
+			base = 10
+		}
+	case base < 2 || base > 36:
+		return NaN
+	}
+
+	end := 0
+	for end < len(s) && digitValue(rune(s[end])) < base {
+		end++
+	}
+	if end == 0 {
+		return NaN
+	}
+
+	// Decimal numbers are parsed as such, to round them correctly.
+	if base == 10 {
+		f, _ := strconv.ParseFloat(s[:end], 64)
+		return sign * f
+	}
+
+	f, _ := parseDigits(s[:end], base)
+	return sign * f
+}
+
+// toInt32 converts f to a 32-bit integer, like JavaScript does for bitwise
+// operations.
+func toInt32(f float64) int32 {
+	return int32(toUint32(f))
+}
+
+// stringStatics returns the properties of the String function.
+func (mod *Module) stringStatics() jsProperties {
+	return jsProperties{
+		"fromCharCode": &jsFunction{
+			fn: func(args []any) any {
+				units := make([]uint16, len(args))
+				for i, f := range numbers(args) {
+					units[i] = uint16(toUint32(f))
+				}
+				return stringOf(units)
+			},
+		},
+		"fromCodePoint": &jsFunction{
+			fn: func(args []any) any {
+				runes := make([]rune, len(args))
+				for i, f := range numbers(args) {
+					// Unlike fromCharCode, fromCodePoint does not wrap around.
+					if f != math.Trunc(f) || f < 0 || f > unicode.MaxRune {
+						return &jsThrow{value: mod.newError("RangeError", "Invalid code point "+toString(f))}
+					}
+					runes[i] = rune(f)
+				}
+				return string(runes)
+			},
+		},
+	}
+}
@@ -0,0 +1,234 @@
+package wasmexec
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	mod := newTestModule()
+	builtins := mod.builtins()
+
+	tests := []struct {
+		fn   string
+		args []any
+		want string
+	}{
+		{fn: "isNaN", args: []any{"abc"}, want: "true"},
+		{fn: "isNaN", args: []any{" 12 "}, want: "false"},
+		{fn: "isFinite", args: []any{"1e1000"}, want: "false"},
+		{fn: "parseFloat", args: []any{" 3.14abc"}, want: "3.14"},
+		{fn: "parseFloat", args: []any{"1e"}, want: "1"},
+		{fn: "parseFloat", args: []any{"-Infinityx"}, want: "-Infinity"},
+		{fn: "parseFloat", args: []any{".e1"}, want: "NaN"},
+		{fn: "parseInt", args: []any{"  42px"}, want: "42"},
+		{fn: "parseInt", args: []any{"-0x1f"}, want: "-31"},
+		{fn: "parseInt", args: []any{"ff", float64(16)}, want: "255"},
+		{fn: "parseInt", args: []any{"101", float64(2)}, want: "5"},
+		{fn: "parseInt", args: []any{"12", float64(1)}, want: "NaN"},
+		{fn: "parseInt", args: []any{"z", float64(37)}, want: "NaN"},
+		{fn: "Boolean", args: []any{""}, want: "false"},
+		{fn: "Boolean", args: []any{math.NaN()}, want: "false"},
+		{fn: "Boolean", args: []any{&jsArray{}}, want: "true"},
+		{fn: "Number", want: "0"},
+		{fn: "Number", args: []any{" 12 "}, want: "12"},
+		{fn: "Number", args: []any{"0b11"}, want: "3"},
+		{fn: "Number.isInteger", args: []any{float64(5)}, want: "true"},
+		{fn: "Number.isInteger", args: []any{"5"}, want: "false"},
+		{fn: "Number.isNaN", args: []any{"abc"}, want: "false"},
+		{fn: "Number.isSafeInteger", args: []any{float64(1 << 53)}, want: "false"},
+		{fn: "Number.isFinite", args: []any{"1"}, want: "false"},
+		{fn: "String", want: ""},
+		{fn: "String", args: []any{&jsArray{elements: []any{float64(1), nil, "a"}}}, want: "1,,a"},
+		{fn: "String", args: []any{float64(1e21)}, want: "1e+21"},
+		{fn: "String.fromCharCode", args: []any{float64(72), float64(105), float64(0x10048)}, want: "HiH"},
+		{fn: "String.fromCodePoint", args: []any{float64(0x1f600)}, want: "\U0001f600"},
+		{fn: "Math.abs", args: []any{"-2"}, want: "2"},
+		{fn: "Math.max", want: "-Infinity"},
+		{fn: "Math.max", args: []any{float64(1), float64(3), float64(2)}, want: "3"},
+		{fn: "Math.max", args: []any{float64(1), "a"}, want: "NaN"},
+		{fn: "Math.min", want: "Infinity"},
+		{fn: "Math.min", args: []any{float64(1), float64(-3)}, want: "-3"},
+		{fn: "Math.round", args: []any{2.5}, want: "3"},
+		{fn: "Math.round", args: []any{-2.5}, want: "-2"},
+		{fn: "Math.round", args: []any{-2.6}, want: "-3"},
+		{fn: "Math.sign", args: []any{float64(-5)}, want: "-1"},
+		{fn: "Math.trunc", args: []any{-4.7}, want: "-4"},
+		{fn: "Math.pow", args: []any{float64(2), float64(10)}, want: "1024"},
+		{fn: "Math.pow", args: []any{float64(1), math.Inf(1)}, want: "NaN"},
+		{fn: "Math.hypot", args: []any{float64(3), float64(4)}, want: "5"},
+		{fn: "Math.hypot", args: []any{math.NaN(), math.Inf(-1)}, want: "Infinity"},
+		{fn: "Math.hypot", args: []any{math.NaN(), float64(1)}, want: "NaN"},
+		{fn: "Math.clz32", args: []any{float64(1)}, want: "31"},
+		{fn: "Math.clz32", args: []any{float64(0)}, want: "32"},
+		{fn: "Math.imul", args: []any{float64(0xffffffff), float64(5)}, want: "-5"},
+		{fn: "Math.fround", args: []any{5.5}, want: "5.5"},
+		{fn: "Math.fround", args: []any{5.05}, want: "5.050000190734863"},
+		{fn: "JSON.stringify", args: []any{"a\"b\n"}, want: `"a\"b\n"`},
+		{fn: "JSON.stringify", args: []any{math.NaN()}, want: "null"},
+		{fn: "JSON.stringify", args: []any{&jsArray{elements: []any{float64(1), nil, "x"}}}, want: `[1,null,"x"]`},
+		{fn: "JSON.stringify", args: []any{&jsArray{elements: []any{float64(1)}}, nil, float64(2)}, want: "[\n  1\n]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fn, func(t *testing.T) {
+			fn := builtin(t, builtins, test.fn).(*jsFunction)
+			if s := toString(fn.fn(test.args)); s != test.want {
+				t.Errorf("%s(%v): got %q, want %q", test.fn, test.args, s, test.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinsMath(t *testing.T) {
+	mod := newTestModule()
+	builtins := mod.builtins()
+
+	// Math.round rounds to -0 between -0.5 and 0.
+	round := builtin(t, builtins, "Math.round").(*jsFunction)
+	if f := round.fn([]any{-0.4}).(float64); f != 0 || !math.Signbit(f) {
+		t.Errorf("Math.round(-0.4): got %v, want -0", f)
+	}
+
+	random := builtin(t, builtins, "Math.random").(*jsFunction)
+	for i := 0; i < 100; i++ {
+		if f := random.fn(nil).(float64); f < 0 || f >= 1 {
+			t.Fatalf("Math.random(): got %v", f)
+		}
+	}
+}
+
+func TestBuiltinsJSON(t *testing.T) {
+	mod := newTestModule()
+	builtins := mod.builtins()
+	parse := builtin(t, builtins, "JSON.parse").(*jsFunction)
+	stringify := builtin(t, builtins, "JSON.stringify").(*jsFunction)
+
+	tests := []struct {
+		text string
+		want string
+		err  string
+	}{
+		{text: `1`, want: `1`},
+		{text: ` "aé" `, want: `"aé"`},
+		{text: `[1, "a", true, null, {}]`, want: `[1,"a",true,null,{}]`},
+		{text: `{"b": [1, {"c": 2}], "a": null, "1": 1}`, want: `{"1":1,"a":null,"b":[1,{"c":2}]}`},
+		{text: `1e400`, want: `null`},
+		{text: `[1e-400, -1e400]`, want: `[0,null]`},
+		{text: `1 2`, err: "SyntaxError: "},
+		{text: `{"a": 1,}`, err: "SyntaxError: "},
+		{text: `[1`, err: "SyntaxError: "},
+		{text: ``, err: "SyntaxError: "},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			v := parse.fn([]any{test.text})
+			if thrown, ok := v.(*jsThrow); ok {
+				if s := toString(thrown.value); test.err == "" || !strings.HasPrefix(s, test.err) {
+					t.Fatalf("JSON.parse: got %q, want %q", s, test.err)
+				}
+				return
+			}
+			if test.err != "" {
+				t.Fatalf("JSON.parse: got %v, want %q", v, test.err)
+			}
+
+			if s := stringify.fn([]any{v}); s != test.want {
+				t.Fatalf("JSON.stringify: got %v, want %q", s, test.want)
+			}
+		})
+	}
+
+	// An object that holds itself can not be converted to JSON.
+	obj := &jsObject{properties: jsProperties{}}
+	obj.properties["self"] = &jsArray{elements: []any{obj}}
+
+	thrown, ok := stringify.fn([]any{obj}).(*jsThrow)
+	if want := "TypeError: Converting circular structure to JSON"; !ok || toString(thrown.value) != want {
+		t.Errorf("JSON.stringify: got %v, want %q", thrown, want)
+	}
+}
+
+func TestBuiltinsPerformance(t *testing.T) {
+	mod := newTestModule()
+	builtins := mod.builtins()
+
+	now := builtin(t, builtins, "performance.now").(*jsFunction)
+	first := now.fn(nil).(float64)
+	second := now.fn(nil).(float64)
+
+	if first < 0 || second < first {
+		t.Errorf("performance.now(): got %v, then %v", first, second)
+	}
+	if origin := builtin(t, builtins, "performance.timeOrigin"); origin.(float64) <= 0 {
+		t.Errorf("performance.timeOrigin: got %v", origin)
+	}
+}
+
+// builtin returns the built-in at path, which are property names separated by
+// dots.
+func TestBuiltinsErrors(t *testing.T) {
+	mod := newTestModule()
+	builtins := mod.builtins()
+
+	tests := []struct {
+		fn   string
+		args []any
+		err  string
+	}{
+		{fn: "String.fromCodePoint", args: []any{float64(-1)}, err: "RangeError: Invalid code point -1"},
+		{fn: "String.fromCodePoint", args: []any{1.5}, err: "RangeError: Invalid code point 1.5"},
+		{fn: "String.fromCodePoint", args: []any{float64(0x110000)}, err: "RangeError: Invalid code point 1114112"},
+		{fn: "String.fromCodePoint", args: []any{float64(65), "a"}, err: "RangeError: Invalid code point NaN"},
+		{fn: "String.fromCodePoint", args: []any{math.Inf(1)}, err: "RangeError: Invalid code point Infinity"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fn, func(t *testing.T) {
+			fn := builtin(t, builtins, test.fn).(*jsFunction)
+			thrown, ok := fn.fn(test.args).(*jsThrow)
+			if !ok {
+				t.Fatalf("%s(%v): got no error, want %q", test.fn, test.args, test.err)
+			}
+			if s := toString(thrown.value); s != test.err {
+				t.Errorf("%s(%v): got %q, want %q", test.fn, test.args, s, test.err)
+			}
+		})
+	}
+
+	// Math.random fails rather than returning a number that is not random if
+	// the source of entropy runs out.
+	mod.entropy = strings.NewReader("1234")
+	random := builtin(t, builtins, "Math.random").(*jsFunction)
+	thrown, ok := random.fn(nil).(*jsThrow)
+	if !ok {
+		t.Fatal("Math.random(): got no error")
+	}
+	if s, want := toString(thrown.value), "Error: Math.random: unexpected EOF"; s != want {
+		t.Errorf("Math.random(): got %q, want %q", s, want)
+	}
+}
+
+func builtin(t *testing.T, builtins jsProperties, path string) any {
+	t.Helper()
+
+	var v any = &jsObject{properties: builtins}
+	for _, name := range strings.Split(path, ".") {
+		var properties jsProperties
+		switch vv := v.(type) {
+		case *jsObject:
+			properties = vv.properties
+		case *jsFunction:
+			properties = vv.properties
+		}
+
+		var ok bool
+		if v, ok = properties[name]; !ok {
+			t.Fatalf("%s: %s is not defined", path, name)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,132 @@
+package wasmexec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Class describes a constructor that the host defines for the guest. The
+// objects it creates are instances of the class, and of every class it
+// extends, as far as instanceof is concerned.
+type Class struct {
+	// Extends is the path of the constructor that the class extends, like
+	// "Error" or "shapes.Shape". If it is empty, the class extends Object.
+	Extends string
+
+	// New returns a new instance for the arguments passed to the
+	// constructor. The returned value is converted like the result of a
+	// Func. If it becomes an object, the class is recorded as its
+	// constructor. If New is nil, the constructor of the class it extends
+	// creates the instance instead.
+	New Func
+}
+
+// RegisterClass makes the constructor described by class available to the
+// guest under path, like RegisterFunc does for a function. The name of the
+// class is the last property name of the path. For instance, registering
+// "shapes.Circle" allows the guest to create a circle with
+// js.Global().Get("shapes").Get("Circle").New(1.5).
+//
+// RegisterClass must not be called from within a Func, as that would
+// deadlock.
+func (mod *Module) RegisterClass(path string, class Class) error {
+	return mod.register(path, func() (any, error) {
+		var parent *jsFunction
+		if class.Extends != "" {
+			v, err := mod.lookup(class.Extends)
+			if err != nil {
+				return nil, err
+			}
+
+			var ok bool
+			if parent, ok = v.(*jsFunction); !ok {
+				return nil, fmt.Errorf("%s: not a constructor", class.Extends)
+			}
+		}
+
+		return mod.classConstructor(path[strings.LastIndex(path, ".")+1:], parent, class.New), nil
+	})
+}
+
+// classConstructor returns a constructor with the specified name that extends
+// parent, if it is not nil.
+func (mod *Module) classConstructor(name string, parent *jsFunction, newFn Func) *jsFunction {
+	var construct *jsFunction
+	if newFn != nil {
+		construct = mod.hostFunction(newFn)
+	}
+
+	fn := &jsFunction{name: name, extends: parent}
+	fn.fn = func(args []any) any {
+		var result any
+		switch {
+		case construct != nil:
+			result = construct.fn(args)
+		case parent != nil:
+			result = parent.fn(args)
+		default:
+			result = &jsObject{properties: make(jsProperties)}
+		}
+
+		if obj, ok := result.(*jsObject); ok {
+			obj.constructor = fn
+		}
+
+		return result
+	}
+
+	return fn
+}
+
+// constructorOf returns the constructor that created v, or nil if v is not an
+// object.
+func (mod *Module) constructorOf(v any) *jsFunction {
+	switch vv := v.(type) {
+	case *jsObject:
+		if vv.constructor != nil {
+			return vv.constructor
+		}
+		return mod.constructors["Object"]
+	case *jsArray, []any:
+		return mod.constructors["Array"]
+	case *jsUint8Array, []byte:
+		return mod.constructors["Uint8Array"]
+	case *Promise:
+		return mod.constructors["Promise"]
+	case *jsTypedArray:
+		return mod.constructors[vv.elemKind.name]
+	case *jsArrayBuffer:
+		return mod.constructors["ArrayBuffer"]
+	case *jsDataView:
+		return mod.constructors["DataView"]
+	case *jsDate:
+		return mod.constructors["Date"]
+	case jsProperties, *jsFunction, HostObject:
+		return mod.constructors["Object"]
+	default:
+		return nil
+	}
+}
+
+// instanceOf returns true if v is an instance of the constructor t, which is
+// the case if t created v, or if the constructor that created v extends t.
+// Every object is an instance of Object.
+func (mod *Module) instanceOf(v any, t *jsFunction) bool {
+	fn := mod.constructorOf(v)
+	if fn == nil {
+		return false
+	}
+
+	return extends(fn, t) || t == mod.constructors["Object"]
+}
+
+// extends returns true if fn is t, or extends t.
+func extends(fn, t *jsFunction) bool {
+	for ; fn != nil; fn = fn.extends {
+		if fn == t {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package wasmexec
+
+import "time"
+
+// Clock is the source of time for the guest.
+type Clock interface {
+	// Nanotime returns a monotonic time in nanoseconds. Only the difference
+	// between two readings is meaningful to the guest.
+	Nanotime() int64
+
+	// Walltime returns the current wall clock time.
+	Walltime() time.Time
+
+	// Location returns the time zone of the guest.
+	Location() *time.Location
+}
+
+// clocker describes an instance that has implemented a clock for the guest.
+type clocker interface {
+	Clock() Clock
+}
+
+// locationer describes an instance that has implemented the time zone of the
+// guest, which overrides the time zone of its clock.
+type locationer interface {
+	Location() *time.Location
+}
+
+// zonedClock is a Clock with a different time zone than the Clock it wraps.
+type zonedClock struct {
+	Clock
+	location *time.Location
+}
+
+// Location implements Clock.
+func (c *zonedClock) Location() *time.Location {
+	return c.location
+}
+
+// systemClock is the Clock of the host, which is used when the instance does
+// not implement clocker.
+type systemClock struct {
+	start time.Time
+}
+
+// newSystemClock returns a new systemClock.
+func newSystemClock() *systemClock {
+	return &systemClock{start: time.Now()}
+}
+
+// Nanotime implements Clock. Like performance.timeOrigin+performance.now()
+// in JavaScript, it counts from the Unix epoch.
+func (c *systemClock) Nanotime() int64 {
+	return c.start.UnixNano() + int64(time.Since(c.start))
+}
+
+// Walltime implements Clock.
+func (c *systemClock) Walltime() time.Time {
+	return time.Now()
+}
+
+// Location implements Clock.
+func (c *systemClock) Location() *time.Location {
+	return time.Local
+}
+
+// virtualClock is a Clock that stands still until it is advanced.
+type virtualClock struct {
+	nanotime int64
+	walltime time.Time
+	location *time.Location
+}
+
+// newVirtualClock returns a new virtualClock that starts at the current time
+// of clock.
+func newVirtualClock(clock Clock) *virtualClock {
+	return &virtualClock{
+		nanotime: clock.Nanotime(),
+		walltime: clock.Walltime(),
+		location: clock.Location(),
+	}
+}
+
+// Nanotime implements Clock.
+func (c *virtualClock) Nanotime() int64 {
+	return c.nanotime
+}
+
+// Walltime implements Clock.
+func (c *virtualClock) Walltime() time.Time {
+	return c.walltime
+}
+
+// Location implements Clock.
+func (c *virtualClock) Location() *time.Location {
+	return c.location
+}
+
+// advanceTo moves the clock forward to the specified monotonic time. The
+// clock never moves backwards.
+func (c *virtualClock) advanceTo(nanotime int64) {
+	if nanotime > c.nanotime {
+		c.walltime = c.walltime.Add(time.Duration(nanotime - c.nanotime))
+		c.nanotime = nanotime
+	}
+}
@@ -0,0 +1,302 @@
+package wasmexec
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// decimalLiteral matches the decimal numbers that a string can hold when it is
+// converted to a number.
+var decimalLiteral = regexp.MustCompile(`^[+-]?(?:(?:\d+\.?\d*|\.\d+)(?:[eE][+-]?\d+)?|Infinity)$`)
+
+// toBoolean converts v to a bool, like Boolean(v) in JavaScript.
+func toBoolean(v any) bool {
+	switch vv := normalize(v).(type) {
+	case nil:
+		return false
+	case bool:
+		return vv
+	case float64:
+		return vv != 0 && !math.IsNaN(vv)
+	case string:
+		return vv != ""
+	default:
+		return true
+	}
+}
+
+// toNumber converts v to a number, like Number(v) in JavaScript. Objects
+// convert through their string form, so that an array with a single number
+// converts to that number. Null and undefined can not be told apart, so both
+// convert to 0, like null does.
+func toNumber(v any) float64 {
+	switch vv := normalize(v).(type) {
+	case nil:
+		return 0
+	case bool:
+		if vv {
+			return 1
+		}
+		return 0
+	case float64:
+		return vv
+	case string:
+		return parseNumber(vv)
+	case *jsArray, typedArray:
+		return parseNumber(toString(vv))
+	case *jsDate:
+		return vv.ms
+	default:
+		return math.NaN()
+	}
+}
+
+// parseNumber converts a string to a number, like Number(s) in JavaScript.
+// Unlike parseFloat, the whole string has to be a number, apart from any
+// surrounding white space.
+func parseNumber(s string) float64 {
+	s = trimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	if len(s) > 2 && s[0] == '0' {
+		base := 0
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+
+		if base != 0 {
+			f, ok := parseDigits(s[2:], base)
+			if !ok {
+				return math.NaN()
+			}
+			return f
+		}
+	}
+
+	if !decimalLiteral.MatchString(s) {
+		return math.NaN()
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !isRangeError(err) {
+		return math.NaN()
+	}
+
+	return f
+}
+
+// parseDigits parses all of s as an integer in the specified base.
+func parseDigits(s string, base int) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	var f float64
+	for _, c := range s {
+		d := digitValue(c)
+		if d >= base {
+			return 0, false
+		}
+		f = f*float64(base) + float64(d)
+	}
+
+	return f, true
+}
+
+// digitValue returns the value of c as a digit, or 36 if c is not a digit in
+// any base.
+func digitValue(c rune) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10
+	default:
+		return 36
+	}
+}
+
+// isRangeError returns true if err reports a number that is too large or too
+// small for a float64, for which strconv.ParseFloat returns infinity or zero,
+// like JavaScript does.
+func isRangeError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrRange
+}
+
+// trimSpace removes the white space and line terminators of JavaScript from
+// both ends of s.
+func trimSpace(s string) string {
+	return strings.TrimFunc(s, isSpace)
+}
+
+// isSpace returns true if r is white space or a line terminator in JavaScript.
+func isSpace(r rune) bool {
+	switch r {
+	case '\t', '\n', '\v', '\f', '\r', ' ', '\u00a0', '\u1680', '\u2028', '\u2029', '\u202f', '\u205f', '\u3000', '\ufeff':
+		return true
+	default:
+		return r >= '\u2000' && r <= '\u200a'
+	}
+}
+
+// toString converts v to a string, like String(v) in JavaScript. Null and
+// undefined can not be told apart, so both convert to "null".
+func toString(v any) string {
+	switch vv := normalize(v).(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(vv)
+	case float64:
+		return formatNumber(vv)
+	case string:
+		return vv
+
+	case *jsArray:
+		return joinArray(vv, ",", nil)
+
+	case typedArray:
+		elements := make([]string, typedArrayLength(vv))
+		for i := range elements {
+			f, _ := typedArrayIndex(vv, i)
+			elements[i] = formatNumber(f)
+		}
+		return strings.Join(elements, ",")
+
+	case *jsObject:
+		if isErrorType(vv.constructor) {
+			name, _ := normalize(vv.properties["name"]).(string)
+			message, _ := normalize(vv.properties["message"]).(string)
+			switch {
+			case message == "":
+				return name
+			case name == "":
+				return message
+			default:
+				return name + ": " + message
+			}
+		}
+		return "[object Object]"
+
+	case *jsFunction:
+		return "function " + vv.name + "() { [native code] }"
+	case *Promise:
+		return "[object Promise]"
+	case *jsArrayBuffer:
+		return "[object ArrayBuffer]"
+	case *jsDataView:
+		return "[object DataView]"
+	case *jsDate:
+		return vv.String()
+	default:
+		return "[object Object]"
+	}
+}
+
+// joinArray joins the elements of a with separator, like a.join(separator)
+// in JavaScript. Undefined and null elements are joined as empty strings, and
+// so are the arrays in joining, which are being joined already. This is how
+// an array that holds itself, directly or indirectly, is joined.
+func joinArray(a *jsArray, separator string, joining []*jsArray) string {
+	for _, b := range joining {
+		if a == b {
+			return ""
+		}
+	}
+	joining = append(joining, a)
+
+	elements := make([]string, len(a.elements))
+	for i, element := range a.elements {
+		switch e := normalize(element).(type) {
+		case nil:
+		case *jsArray:
+			elements[i] = joinArray(e, ",", joining)
+		default:
+			elements[i] = toString(e)
+		}
+	}
+
+	return strings.Join(elements, separator)
+}
+
+// formatNumber formats f like JavaScript does when it converts a number to a
+// string: with the fewest digits that identify f, and in exponent notation if
+// f is smaller than 1e-6 or at least 1e21.
+func formatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	case f < 0:
+		return "-" + formatNumber(-f)
+	}
+
+	// The shortest representation in exponent notation gives the digits and
+	// the position of the decimal point.
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp, _ := strings.Cut(s, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	n, _ := strconv.Atoi(exp)
+	n++
+	k := len(digits)
+
+	switch {
+	case k <= n && n <= 21:
+		return digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return "0." + strings.Repeat("0", -n) + digits
+	}
+
+	sign := "+"
+	if n-1 < 0 {
+		sign = "-"
+	}
+
+	exponent := "e" + sign + strconv.Itoa(abs(n-1))
+	if k == 1 {
+		return digits + exponent
+	}
+
+	return digits[:1] + "." + digits[1:] + exponent
+}
+
+// abs returns the absolute value of i.
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+
+	return i
+}
+
+// utf16Of returns the UTF-16 code units of s, which JavaScript strings are
+// made of.
+func utf16Of(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+// stringOf returns the string for UTF-16 code units. Unpaired surrogates
+// become the replacement character.
+func stringOf(u []uint16) string {
+	return string(utf16.Decode(u))
+}
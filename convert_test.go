@@ -0,0 +1,115 @@
+package wasmexec
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToString(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{name: "true", value: true, want: "true"},
+		{name: "integer", value: float64(100), want: "100"},
+		{name: "fraction", value: 1.25, want: "1.25"},
+		{name: "shortest", value: 0.30000000000000004, want: "0.30000000000000004"},
+		{name: "1e21", value: 1e21, want: "1e+21"},
+		{name: "-1e21", value: -1e21, want: "-1e+21"},
+		{name: "below 1e21", value: 123456789012345680000.0, want: "123456789012345680000"},
+		{name: "1e-6", value: 1e-6, want: "0.000001"},
+		{name: "1e-7", value: 1e-7, want: "1e-7"},
+		{name: "1.5e-7", value: 1.5e-7, want: "1.5e-7"},
+		{name: "largest", value: math.MaxFloat64, want: "1.7976931348623157e+308"},
+		{name: "smallest", value: 5e-324, want: "5e-324"},
+		{name: "-0", value: math.Copysign(0, -1), want: "0"},
+		{name: "NaN", value: math.NaN(), want: "NaN"},
+		{name: "Infinity", value: math.Inf(1), want: "Infinity"},
+		{name: "-Infinity", value: math.Inf(-1), want: "-Infinity"},
+		{name: "string", value: "0x1f", want: "0x1f"},
+		{name: "empty array", value: &jsArray{}, want: ""},
+		{name: "array", value: &jsArray{elements: []any{float64(1), "a", true}}, want: "1,a,true"},
+		{name: "array with null", value: &jsArray{elements: []any{nil, float64(1), nil}}, want: ",1,"},
+		{name: "nested array", value: &jsArray{elements: []any{float64(1), &jsArray{elements: []any{float64(2), float64(3)}}}}, want: "1,2,3"},
+		{name: "object", value: &jsObject{}, want: "[object Object]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if s := toString(test.value); s != test.want {
+				t.Errorf("String(%v): got %q, want %q", test.value, s, test.want)
+			}
+		})
+	}
+}
+
+func TestToNumber(t *testing.T) {
+	negativeZero := math.Copysign(0, -1)
+
+	tests := []struct {
+		name  string
+		value any
+		want  float64
+	}{
+		{name: "true", value: true, want: 1},
+		{name: "false", value: false, want: 0},
+		{name: "empty", value: "", want: 0},
+		{name: "white space", value: " \t\n", want: 0},
+		{name: "integer", value: "12", want: 12},
+		{name: "surrounding white space", value: " 12 ", want: 12},
+		{name: "fraction", value: ".5", want: 0.5},
+		{name: "trailing point", value: "5.", want: 5},
+		{name: "exponent", value: "1e21", want: 1e21},
+		{name: "negative exponent", value: "1e-7", want: 1e-7},
+		{name: "incomplete exponent", value: "1e", want: NaN},
+		{name: "overflow", value: "1e1000", want: math.Inf(1)},
+		{name: "-0", value: "-0", want: negativeZero},
+		{name: "Infinity", value: "Infinity", want: math.Inf(1)},
+		{name: "-Infinity", value: "-Infinity", want: math.Inf(-1)},
+		{name: "lowercase infinity", value: "infinity", want: NaN},
+		{name: "NaN", value: "NaN", want: NaN},
+		{name: "hexadecimal", value: "0x1f", want: 31},
+		{name: "octal", value: "0o17", want: 15},
+		{name: "binary", value: "0b101", want: 5},
+		{name: "signed hexadecimal", value: "-0x1f", want: NaN},
+		{name: "invalid hexadecimal", value: "0x1g", want: NaN},
+		{name: "separator", value: "1_000", want: NaN},
+		{name: "trailing characters", value: "12px", want: NaN},
+		{name: "number", value: math.Inf(-1), want: math.Inf(-1)},
+		{name: "empty array", value: &jsArray{}, want: 0},
+		{name: "array with a number", value: &jsArray{elements: []any{" 5 "}}, want: 5},
+		{name: "array with numbers", value: &jsArray{elements: []any{float64(1), float64(2)}}, want: NaN},
+		{name: "object", value: &jsObject{}, want: NaN},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := toNumber(test.value)
+
+			switch {
+			case math.IsNaN(test.want):
+				if !math.IsNaN(f) {
+					t.Errorf("Number(%v): got %v, want NaN", test.value, f)
+				}
+			case f != test.want || math.Signbit(f) != math.Signbit(test.want):
+				t.Errorf("Number(%v): got %v, want %v", test.value, f, test.want)
+			}
+		})
+	}
+}
+
+func TestToStringCycle(t *testing.T) {
+	a := &jsArray{elements: []any{float64(1), nil}}
+	b := &jsArray{elements: []any{a, "b"}}
+	a.elements = append(a.elements, a, b)
+
+	if s := toString(a); s != "1,,,,b" {
+		t.Errorf("String(a): got %q, want %q", s, "1,,,,b")
+	}
+
+	join := newTestModule().arrayGet(a, "join").(*jsFunction)
+	if s := join.fn([]any{"-"}); s != "1---,b" {
+		t.Errorf("a.join(\"-\"): got %q, want %q", s, "1---,b")
+	}
+}
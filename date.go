@@ -0,0 +1,359 @@
+package wasmexec
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTime is the largest number of milliseconds from the Unix epoch that a
+// Date can hold, in either direction.
+const maxTime = 8.64e15
+
+// isoDate matches the date time string format of JavaScript, which is a
+// simplification of ISO 8601.
+var isoDate = regexp.MustCompile(`^([+-]\d{6}|\d{4})(?:-(\d{2})(?:-(\d{2}))?)?(?:T(\d{2}):(\d{2})(?::(\d{2})(?:\.(\d{1,9}))?)?)?(Z|[+-]\d{2}:\d{2})?$`)
+
+// jsDate describes a Date object, which holds a point in time as the number
+// of milliseconds from the Unix epoch. It is NaN for an invalid date.
+type jsDate struct {
+	ms float64
+
+	// location is the time zone of the guest, in which the local getters
+	// return their results.
+	location *time.Location
+}
+
+// dateConstructor returns the Date constructor of the global object, along with
+// its static methods now, parse and UTC.
+func (mod *Module) dateConstructor() *jsFunction {
+	return &jsFunction{
+		name: "Date",
+		properties: jsProperties{
+			"now": &jsFunction{
+				fn: func([]any) any {
+					return float64(mod.clock.Walltime().UnixMilli())
+				},
+			},
+			"parse": &jsFunction{
+				fn: func(args []any) any {
+					return parseDate(toString(arg(args, 0)), mod.clock.Location())
+				},
+			},
+			"UTC": &jsFunction{
+				fn: func(args []any) any {
+					return makeDate(numbers(args), time.UTC)
+				},
+			},
+		},
+		fn: func(args []any) any {
+			location := mod.clock.Location()
+			d := &jsDate{location: location}
+
+			switch {
+			case len(args) == 0:
+				d.ms = float64(mod.clock.Walltime().UnixMilli())
+			case len(args) > 1:
+				d.ms = makeDate(numbers(args), location)
+			default:
+				switch v := normalize(args[0]).(type) {
+				case *jsDate:
+					d.ms = v.ms
+				case string:
+					d.ms = parseDate(v, location)
+				default:
+					d.ms = timeClip(toNumber(v))
+				}
+			}
+
+			return d
+		},
+	}
+}
+
+// timeClip returns ms as a whole number of milliseconds, or NaN if it is out
+// of the range of a Date.
+func timeClip(ms float64) float64 {
+	if math.IsNaN(ms) || math.Abs(ms) > maxTime {
+		return NaN
+	}
+
+	return math.Trunc(ms)
+}
+
+// makeDate returns the time for the components of a date, which are the year,
+// month, day, hours, minutes, seconds and milliseconds, in that order. Only
+// the year is required. Like in JavaScript, a year from 0 to 99 means a year
+// of the 20th century, and components out of range carry over.
+func makeDate(components []float64, location *time.Location) float64 {
+	// The components default to the first moment of the year.
+	c := []float64{NaN, 0, 1, 0, 0, 0, 0}
+	copy(c, components)
+
+	for i := range c {
+		if math.IsNaN(c[i]) || math.IsInf(c[i], 0) {
+			return NaN
+		}
+		c[i] = math.Trunc(c[i])
+	}
+
+	// Keep the components within a range that time.Date handles, which is
+	// far beyond the range of a Date.
+	for i, limit := range []float64{1e6, 1e7, 1e9, 1e10, 1e12, 1e13, 1e15} {
+		if math.Abs(c[i]) > limit {
+			return NaN
+		}
+	}
+
+	if c[0] >= 0 && c[0] <= 99 {
+		c[0] += 1900
+	}
+
+	t := time.Date(int(c[0]), time.Month(c[1]+1), int(c[2]), int(c[3]), int(c[4]), int(c[5]), 0, location)
+	return timeClip(float64(t.UnixMilli()) + c[6])
+}
+
+// parseDate parses s like Date.parse, which accepts the date time string
+// format of JavaScript and the formats of toString and toUTCString. A date
+// without a time is in UTC, while a date and time without an offset is in the
+// local time zone. It returns NaN if s can not be parsed.
+func parseDate(s string, location *time.Location) float64 {
+	s = trimSpace(s)
+
+	m := isoDate.FindStringSubmatch(s)
+	if m == nil {
+		return parseDateString(s)
+	}
+
+	// The extended year -000000 is not allowed.
+	if m[1] == "-000000" {
+		return NaN
+	}
+
+	component := func(s string, def int) int {
+		if s == "" {
+			return def
+		}
+		i, _ := strconv.Atoi(s)
+		return i
+	}
+
+	year := component(m[1], 0)
+	month := component(m[2], 1)
+	day := component(m[3], 1)
+	hour := component(m[4], 0)
+	minute := component(m[5], 0)
+	second := component(m[6], 0)
+	nsec := component((m[7] + "000000000")[:9], 0)
+
+	if month < 1 || month > 12 || day < 1 || day > daysIn(year, month) || hour > 24 || minute > 59 || second > 59 {
+		return NaN
+	}
+	if hour == 24 && (minute != 0 || second != 0 || nsec != 0) {
+		return NaN
+	}
+
+	switch zone := m[8]; {
+	case zone == "Z" || (zone == "" && m[4] == ""):
+		location = time.UTC
+	case zone != "":
+		offset := component(zone[1:3], 0)*3600 + component(zone[4:6], 0)*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, minute, second, nsec, location)
+	return timeClip(float64(t.UnixMilli()))
+}
+
+// parseDateString parses s in the formats of toString and toUTCString.
+func parseDateString(s string) float64 {
+	// Leave out the name of the time zone that toString ends with.
+	if i := strings.Index(s, " ("); i >= 0 && strings.HasSuffix(s, ")") {
+		s = s[:i]
+	}
+
+	for _, layout := range []string{
+		"Mon Jan 02 2006 15:04:05 GMT-0700",
+		"Mon, 02 Jan 2006 15:04:05 GMT",
+		"Mon Jan 02 2006",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return timeClip(float64(t.UnixMilli()))
+		}
+	}
+
+	return NaN
+}
+
+// daysIn returns the number of days in a month of a year.
+func daysIn(year, month int) int {
+	return time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// valid returns true if the date holds a point in time.
+func (d *jsDate) valid() bool {
+	return !math.IsNaN(d.ms)
+}
+
+// time returns the point in time of the date in the specified time zone.
+func (d *jsDate) time(location *time.Location) time.Time {
+	return time.UnixMilli(int64(d.ms)).In(location)
+}
+
+// isoString formats the date like toISOString does.
+func (d *jsDate) isoString() string {
+	t := d.time(time.UTC)
+
+	year := strconv.Itoa(t.Year())
+	switch {
+	case t.Year() < 0:
+		year = "-" + leftPad(strconv.Itoa(-t.Year()), 6)
+	case t.Year() > 9999:
+		year = "+" + leftPad(year, 6)
+	default:
+		year = leftPad(year, 4)
+	}
+
+	return year + t.Format("-01-02T15:04:05.000Z")
+}
+
+// String formats the date like toString does, or returns "Invalid Date".
+func (d *jsDate) String() string {
+	if !d.valid() {
+		return "Invalid Date"
+	}
+
+	return d.dateString() + " " + d.timeString()
+}
+
+// dateString formats the date part of the date in the local time zone.
+func (d *jsDate) dateString() string {
+	t := d.time(d.location)
+	return t.Format("Mon Jan 02 ") + leftPad(strconv.Itoa(t.Year()), 4)
+}
+
+// timeString formats the time part of the date in the local time zone,
+// followed by the offset and the name of the time zone.
+func (d *jsDate) timeString() string {
+	t := d.time(d.location)
+	name, _ := t.Zone()
+
+	return t.Format("15:04:05 GMT-0700") + " (" + name + ")"
+}
+
+// leftPad pads s with zeros on the left up to the specified length.
+func leftPad(s string, length int) string {
+	if len(s) >= length {
+		return s
+	}
+
+	return strings.Repeat("0", length-len(s)) + s
+}
+
+// dateGetters holds the getters of a Date, which exist in a local and a UTC
+// variant, like getHours and getUTCHours.
+var dateGetters = map[string]func(t time.Time) int{
+	"FullYear":     time.Time.Year,
+	"Month":        func(t time.Time) int { return int(t.Month()) - 1 },
+	"Date":         time.Time.Day,
+	"Day":          func(t time.Time) int { return int(t.Weekday()) },
+	"Hours":        time.Time.Hour,
+	"Minutes":      time.Time.Minute,
+	"Seconds":      time.Time.Second,
+	"Milliseconds": func(t time.Time) int { return t.Nanosecond() / 1e6 },
+}
+
+// get returns the property name of the date, which are its methods.
+func (d *jsDate) get(mod *Module, name string) any {
+	// number returns a method that returns a number, which is NaN for an
+	// invalid date.
+	number := func(fn func() float64) *jsFunction {
+		return &jsFunction{
+			fn: func([]any) any {
+				if !d.valid() {
+					return NaN
+				}
+				return fn()
+			},
+		}
+	}
+
+	// text returns a method that returns a string, which is "Invalid Date"
+	// for an invalid date.
+	text := func(fn func() string) *jsFunction {
+		return &jsFunction{
+			fn: func([]any) any {
+				if !d.valid() {
+					return "Invalid Date"
+				}
+				return fn()
+			},
+		}
+	}
+
+	if getter, ok := dateGetters[strings.TrimPrefix(name, "getUTC")]; ok && strings.HasPrefix(name, "getUTC") {
+		return number(func() float64 {
+			return float64(getter(d.time(time.UTC)))
+		})
+	}
+	if getter, ok := dateGetters[strings.TrimPrefix(name, "get")]; ok && strings.HasPrefix(name, "get") {
+		return number(func() float64 {
+			return float64(getter(d.time(d.location)))
+		})
+	}
+
+	switch name {
+	case "getTime", "valueOf":
+		return &jsFunction{
+			fn: func([]any) any {
+				return d.ms
+			},
+		}
+
+	case "getTimezoneOffset":
+		return number(func() float64 {
+			_, offset := d.time(d.location).Zone()
+			return float64(-offset) / 60
+		})
+
+	case "toISOString":
+		return &jsFunction{
+			fn: func([]any) any {
+				if !d.valid() {
+					return &jsThrow{value: mod.newError("RangeError", "Invalid time value")}
+				}
+				return d.isoString()
+			},
+		}
+
+	case "toJSON":
+		return &jsFunction{
+			fn: func([]any) any {
+				if !d.valid() {
+					return nil
+				}
+				return d.isoString()
+			},
+		}
+
+	case "toString":
+		return text(d.String)
+	case "toDateString":
+		return text(d.dateString)
+	case "toTimeString":
+		return text(d.timeString)
+	case "toUTCString":
+		return text(func() string {
+			t := d.time(time.UTC)
+			return t.Format("Mon, 02 Jan ") + leftPad(strconv.Itoa(t.Year()), 4) + t.Format(" 15:04:05 GMT")
+		})
+
+	default:
+		return nil
+	}
+}
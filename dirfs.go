@@ -0,0 +1,223 @@
+package wasmexec
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// dirFS is a WritableFS that is rooted in a directory on the host.
+type dirFS struct {
+	root string
+}
+
+// DirFS returns a WritableFS for the tree of files rooted at the directory
+// dir on the host. The guest is unable to reach outside of this directory,
+// either through ".." or by following a symbolic link.
+func DirFS(dir string) WritableFS {
+	return &dirFS{root: dir}
+}
+
+// join returns the host path for the specified name, after making sure that
+// it does not escape the root directory.
+func (dir *dirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) || runtime.GOOS == "windows" && strings.ContainsAny(name, `\:`) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	root, err := filepath.EvalSymlinks(dir.root)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	fullpath := filepath.Join(root, filepath.FromSlash(name))
+
+	// Resolve the path, including a symbolic link at its end that points to
+	// a file that does not exist yet, and make sure it still points to a
+	// location within the root directory. Otherwise, creating a file through
+	// such a link would create it outside of the root directory.
+	resolved, err := resolveHostPath(fullpath, 0)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+
+	return fullpath, nil
+}
+
+// maxLinks is the maximum number of symbolic links that resolveHostPath
+// follows, to protect against links that point to each other.
+const maxLinks = 255
+
+// resolveHostPath returns p with all symbolic links resolved, like
+// filepath.EvalSymlinks does, except that p does not have to exist. The part
+// of p that does not exist is resolved as far as possible, so a symbolic link
+// that points to a file that does not exist is resolved to that file. The
+// number of links followed so far is passed in links.
+func resolveHostPath(p string, links int) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return resolved, err
+	}
+
+	parent := filepath.Dir(p)
+	if parent == p {
+		return "", err
+	}
+
+	parent, err = resolveHostPath(parent, links)
+	if err != nil {
+		return "", err
+	}
+	p = filepath.Join(parent, filepath.Base(p))
+
+	info, err := os.Lstat(p)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return p, nil
+	case err != nil:
+		return "", err
+	case info.Mode()&fs.ModeSymlink == 0:
+		return p, nil
+	}
+
+	if links++; links > maxLinks {
+		return "", syscall.ELOOP
+	}
+
+	target, err := os.Readlink(p)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(parent, target)
+	}
+
+	return resolveHostPath(target, links)
+}
+
+// Open implements fs.FS.
+func (dir *dirFS) Open(name string) (fs.File, error) {
+	fullpath, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(fullpath)
+}
+
+// Stat implements fs.StatFS.
+func (dir *dirFS) Stat(name string) (fs.FileInfo, error) {
+	fullpath, err := dir.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(fullpath)
+}
+
+// OpenFile implements WritableFS.
+func (dir *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	fullpath, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(fullpath, flag, perm)
+}
+
+// Mkdir implements WritableFS.
+func (dir *dirFS) Mkdir(name string, perm fs.FileMode) error {
+	fullpath, err := dir.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Mkdir(fullpath, perm)
+}
+
+// Rename implements WritableFS.
+func (dir *dirFS) Rename(oldname, newname string) error {
+	oldpath, err := dir.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+
+	newpath, err := dir.join("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove implements WritableFS.
+func (dir *dirFS) Remove(name string) error {
+	fullpath, err := dir.join("remove", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(fullpath)
+}
+
+// Truncate implements WritableFS.
+func (dir *dirFS) Truncate(name string, size int64) error {
+	fullpath, err := dir.join("truncate", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Truncate(fullpath, size)
+}
+
+// Chmod implements WritableFS.
+func (dir *dirFS) Chmod(name string, mode fs.FileMode) error {
+	fullpath, err := dir.join("chmod", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(fullpath, mode)
+}
+
+// Chtimes implements WritableFS.
+func (dir *dirFS) Chtimes(name string, atime, mtime time.Time) error {
+	fullpath, err := dir.join("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(fullpath, atime, mtime)
+}
+
+// ScratchFS is a WritableFS that is rooted in a temporary directory on the
+// host. The directory and everything in it is removed on Close.
+type ScratchFS struct {
+	WritableFS
+	dir string
+}
+
+// NewScratchFS returns a new ScratchFS.
+func NewScratchFS() (*ScratchFS, error) {
+	dir, err := os.MkdirTemp("", "wasmexec-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScratchFS{WritableFS: DirFS(dir), dir: dir}, nil
+}
+
+// Close removes the temporary directory.
+func (scratch *ScratchFS) Close() error {
+	return os.RemoveAll(scratch.dir)
+}
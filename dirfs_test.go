@@ -0,0 +1,84 @@
+package wasmexec
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDirFSConfinement(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"outside":         outside,
+		"secret":          filepath.Join(outside, "secret"),
+		"dangling":        filepath.Join(outside, "created"),
+		"dangling-rel":    filepath.Join("..", filepath.Base(outside), "created"),
+		"dangling-inside": "new",
+		"chain":           "dangling",
+		"loop":            "loop",
+		"dir/up":          "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symbolic links are not supported: %v", err)
+		}
+	}
+
+	fsys := DirFS(root)
+
+	tests := []struct {
+		name string
+		flag int
+		err  error
+	}{
+		{name: "file", flag: syscall.O_CREAT | syscall.O_WRONLY},
+		{name: "dir/file", flag: syscall.O_CREAT | syscall.O_WRONLY},
+		{name: "dir/up/file", flag: syscall.O_CREAT | syscall.O_WRONLY},
+		{name: "dangling-inside", flag: syscall.O_CREAT | syscall.O_WRONLY},
+		{name: "../file", flag: syscall.O_CREAT | syscall.O_WRONLY, err: fs.ErrInvalid},
+		{name: "outside/file", flag: syscall.O_CREAT | syscall.O_WRONLY, err: fs.ErrPermission},
+		{name: "secret", flag: syscall.O_RDONLY, err: fs.ErrPermission},
+		{name: "dangling", flag: syscall.O_CREAT | syscall.O_WRONLY, err: fs.ErrPermission},
+		{name: "dangling-rel", flag: syscall.O_CREAT | syscall.O_WRONLY, err: fs.ErrPermission},
+		{name: "chain", flag: syscall.O_CREAT | syscall.O_WRONLY, err: fs.ErrPermission},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := fsys.OpenFile(test.name, test.flag, 0o644)
+			if err == nil {
+				f.Close()
+			}
+
+			switch {
+			case test.err == nil && err != nil:
+				t.Fatalf("OpenFile: %v", err)
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Fatalf("OpenFile: got %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	if _, err := fsys.OpenFile("loop", syscall.O_CREAT|syscall.O_WRONLY, 0o644); err == nil {
+		t.Error("OpenFile: a symbolic link that points to itself was followed")
+	}
+
+	if _, err := os.Lstat(filepath.Join(outside, "created")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("a file was created outside of the root directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new")); err != nil {
+		t.Errorf("the file that dangling-inside points to was not created: %v", err)
+	}
+}
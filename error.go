@@ -0,0 +1,145 @@
+package wasmexec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errorNames holds the names of the error constructors of the global object.
+// All of them but the first extend the first.
+var errorNames = []string{
+	"Error",
+	"EvalError",
+	"RangeError",
+	"ReferenceError",
+	"SyntaxError",
+	"TypeError",
+	"URIError",
+}
+
+// Error is a JavaScript exception. Returning an *Error from a Func throws an
+// Error object with the same name and message in the guest, so a host
+// function can throw a TypeError, for instance. An exception thrown or a
+// promise rejected by the guest is returned to the host as an *Error as well.
+type Error struct {
+	// Name is the name of the error constructor, like "TypeError". It is
+	// empty if the guest threw a value that is not an Error object.
+	Name string
+
+	// Message describes the error. If the guest threw a value that is not an
+	// Error object, this is that value formatted as a string.
+	Message string
+
+	// Stack is the stack trace of the error, if it is known.
+	Stack string
+}
+
+// Error implements the error interface.
+func (err *Error) Error() string {
+	if err.Name == "" {
+		return err.Message
+	}
+
+	return err.Name + ": " + err.Message
+}
+
+// errorConstructors returns the error constructors of the global object,
+// indexed by their name.
+func (mod *Module) errorConstructors() map[string]*jsFunction {
+	constructors := make(map[string]*jsFunction, len(errorNames))
+	for _, name := range errorNames {
+		name := name
+		constructors[name] = &jsFunction{
+			name:    name,
+			extends: constructors[errorNames[0]],
+			fn: func(args []any) any {
+				var message string
+				if msg, ok := normalize(arg(args, 0)).(string); ok {
+					message = msg
+				}
+
+				return mod.newError(name, message)
+			},
+		}
+	}
+
+	return constructors
+}
+
+// newError returns an Error object that is created by the error constructor
+// with the specified name.
+func (mod *Module) newError(name, message string) *jsObject {
+	return &jsObject{
+		constructor: mod.constructors[name],
+		properties: jsProperties{
+			"name":    name,
+			"message": message,
+			"stack":   name + ": " + message,
+		},
+	}
+}
+
+// errorObject returns the Error object for err. If err is an *Error, the
+// Error object has the same name, message and stack.
+func (mod *Module) errorObject(err error) *jsObject {
+	var e *Error
+	if !errors.As(err, &e) {
+		return mod.newError("Error", err.Error())
+	}
+
+	name := e.Name
+	if fn, ok := mod.constructors[name]; !ok || !isErrorType(fn) {
+		name = "Error"
+	}
+
+	obj := mod.newError(name, e.Message)
+	if e.Name != "" {
+		obj.properties["name"] = e.Name
+	}
+	if e.Stack != "" {
+		obj.properties["stack"] = e.Stack
+	}
+
+	return obj
+}
+
+// thrownValue returns the value that is thrown in the guest for err.
+func (mod *Module) thrownValue(err error) any {
+	var t *jsThrow
+	if errors.As(err, &t) {
+		return t.value
+	}
+
+	return mod.errorObject(err)
+}
+
+// exception returns the *Error for a value that the guest has thrown, or with
+// which it has rejected a promise.
+func (mod *Module) exception(v any) *Error {
+	obj, ok := normalize(v).(*jsObject)
+	if !ok || !isErrorType(obj.constructor) {
+		return &Error{Message: toString(v)}
+	}
+
+	e := &Error{}
+	e.Name, _ = normalize(obj.properties["name"]).(string)
+	e.Message, _ = normalize(obj.properties["message"]).(string)
+	e.Stack, _ = normalize(obj.properties["stack"]).(string)
+
+	return e
+}
+
+// isErrorType returns true if fn is the Error constructor, or a constructor
+// that extends it.
+func isErrorType(fn *jsFunction) bool {
+	for fn != nil && fn.extends != nil {
+		fn = fn.extends
+	}
+
+	return fn != nil && fn.name == errorNames[0]
+}
+
+// typeError returns an *Error that throws a TypeError in the guest.
+func typeError(format string, args ...any) *Error {
+	return &Error{Name: "TypeError", Message: fmt.Sprintf(format, args...)}
+}
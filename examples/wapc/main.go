@@ -19,4 +19,7 @@ func main() {
 	wapc.RegisterFunctions(wapc.Functions{
 		"hello": hello,
 	})
+
+	// Keep the program running, so the host can call the functions.
+	select {}
 }
@@ -0,0 +1,931 @@
+package wasmexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"syscall"
+	"time"
+)
+
+// These are the file mode bits as the guest expects them in a stat object.
+// They are defined in syscall/syscall_js.go.
+const (
+	sIFMT   = 0o170000
+	sIFDIR  = 0o040000
+	sIFREG  = 0o100000
+	sIFLNK  = 0o120000
+	sIFIFO  = 0o010000
+	sIFSOCK = 0o140000
+	sIFCHR  = 0o020000
+)
+
+// oDIRECTORY is the O_DIRECTORY flag as exposed to the guest. It is not part
+// of the syscall package on every platform, so it gets a value that does not
+// clash with any of the other flags.
+const oDIRECTORY = 0x10000
+
+// errnoCodes maps host errors to the errno codes the guest understands.
+var errnoCodes = map[syscall.Errno]errno{
+	syscall.EACCES:       eACCES,
+	syscall.EBADF:        eBADF,
+	syscall.EBUSY:        eBUSY,
+	syscall.EEXIST:       eEXIST,
+	syscall.EFBIG:        eFBIG,
+	syscall.EINVAL:       eINVAL,
+	syscall.EIO:          eIO,
+	syscall.EISDIR:       eISDIR,
+	syscall.ELOOP:        eLOOP,
+	syscall.EMFILE:       eMFILE,
+	syscall.ENAMETOOLONG: eNAMETOOLONG,
+	syscall.ENOENT:       eNOENT,
+	syscall.ENOSPC:       eNOSPC,
+	syscall.ENOSYS:       eNOSYS,
+	syscall.ENOTDIR:      eNOTDIR,
+	syscall.ENOTEMPTY:    eNOTEMPTY,
+	syscall.EPERM:        ePERM,
+	syscall.EROFS:        eROFS,
+	syscall.ESPIPE:       eSPIPE,
+	syscall.EXDEV:        eXDEV,
+}
+
+// toErrno converts an error to an errno code.
+func toErrno(err error) errno {
+	var code errno
+	if errors.As(err, &code) {
+		return code
+	}
+
+	var sysErr syscall.Errno
+	if errors.As(err, &sysErr) {
+		if code, ok := errnoCodes[sysErr]; ok {
+			return code
+		}
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return eNOENT
+	case errors.Is(err, fs.ErrExist):
+		return eEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return eACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return eINVAL
+	case errors.Is(err, fs.ErrClosed):
+		return eBADF
+	default:
+		return eIO
+	}
+}
+
+// fileSystem describes an instance that has implemented a file system for
+// the guest.
+type fileSystem interface {
+	FS() fs.FS
+}
+
+// WritableFS describes a file system that the guest is able to modify. The
+// flag passed to OpenFile is a combination of the os.O_* flags.
+//
+// Files returned by OpenFile need to implement io.Writer for the guest to be
+// able to write to them. They may also implement io.WriterAt for positional
+// writes, as well as the Sync(), Truncate() and Chmod() methods of *os.File.
+type WritableFS interface {
+	fs.FS
+
+	OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	Truncate(name string, size int64) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// SymlinkFS describes a file system that supports symbolic links. Lstat and
+// ReadLink do not follow a symbolic link in the last element of name, just
+// like their counterparts in the os package.
+type SymlinkFS interface {
+	fs.FS
+
+	Symlink(oldname, newname string) error
+	ReadLink(name string) (string, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// writeFlags are the open flags that require a WritableFS.
+const writeFlags = syscall.O_WRONLY | syscall.O_RDWR | syscall.O_CREAT | syscall.O_TRUNC | syscall.O_APPEND
+
+// openFile describes a file that was opened by the guest.
+type openFile struct {
+	name string
+	file fs.File
+}
+
+// fsFunction returns a function that calls fn with all arguments except the
+// trailing callback, after which the callback is called with the result of fn.
+// This follows the calling convention of fsCall() in syscall/fs_js.go.
+func fsFunction(fn func(args []any) (any, error)) *jsFunction {
+	return fsAsyncFunction(func(args []any, done func(any, error)) {
+		done(fn(args))
+	})
+}
+
+// fsAsyncFunction is like fsFunction, except that fn calls done itself once
+// it has a result. This can happen after fn has returned, as long as the guest
+// is locked at that time.
+func fsAsyncFunction(fn func(args []any, done func(any, error))) *jsFunction {
+	return &jsFunction{
+		fn: func(args []any) any {
+			if len(args) == 0 {
+				return nil
+			}
+
+			callback, ok := args[len(args)-1].(*jsFunction)
+			if !ok {
+				return nil
+			}
+
+			fn(args[:len(args)-1], func(result any, err error) {
+				if err != nil {
+					callback.fn(errorResponse(toErrno(err)))
+					return
+				}
+
+				callback.fn([]any{nil, result})
+			})
+
+			return nil
+		},
+	}
+}
+
+// argString returns the string argument at index i.
+func argString(args []any, i int) (string, error) {
+	if i >= len(args) {
+		return "", eINVAL
+	}
+
+	switch v := args[i].(type) {
+	case *jsString:
+		return v.data, nil
+	case string:
+		return v, nil
+	default:
+		return "", eINVAL
+	}
+}
+
+// argInt returns the number argument at index i.
+func argInt(args []any, i int) (int64, error) {
+	if i >= len(args) {
+		return 0, eINVAL
+	}
+
+	v, ok := args[i].(float64)
+	if !ok {
+		return 0, eINVAL
+	}
+
+	return int64(v), nil
+}
+
+// resolvePath resolves the paths into an absolute path, like Node's
+// path.resolve() does. The current working directory of the guest is always /.
+func resolvePath(paths ...string) string {
+	resolved := "/"
+	for _, p := range paths {
+		if path.IsAbs(p) {
+			resolved = p
+			continue
+		}
+
+		resolved = path.Join(resolved, p)
+	}
+
+	return path.Clean(resolved)
+}
+
+// fsName converts a guest path to a name that fs.FS accepts. Resolving the
+// path against the root makes it impossible to escape the file system using
+// "..".
+func fsName(p string) string {
+	name := resolvePath(p)[1:]
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// filesys returns the file system of the guest.
+func (mod *Module) filesys() (fs.FS, error) {
+	if mod.fsys == nil {
+		return nil, eNOSYS
+	}
+
+	return mod.fsys, nil
+}
+
+// writableFS returns the file system of the guest if it is writable.
+func (mod *Module) writableFS() (WritableFS, error) {
+	fsys, err := mod.filesys()
+	if err != nil {
+		return nil, err
+	}
+
+	wfs, ok := fsys.(WritableFS)
+	if !ok {
+		return nil, eROFS
+	}
+
+	return wfs, nil
+}
+
+// file returns the open file referenced by the file descriptor at index i.
+func (mod *Module) file(args []any, i int) (*openFile, error) {
+	fd, err := argInt(args, i)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := mod.files[int(fd)]
+	if !ok {
+		return nil, eBADF
+	}
+
+	return f, nil
+}
+
+// statObject returns the stat object that syscall/fs_js.go expects.
+func statObject(info fs.FileInfo) *jsObject {
+	mode := uint32(info.Mode().Perm())
+	switch info.Mode().Type() {
+	case fs.ModeDir:
+		mode |= sIFDIR
+	case fs.ModeSymlink:
+		mode |= sIFLNK
+	case fs.ModeNamedPipe:
+		mode |= sIFIFO
+	case fs.ModeSocket:
+		mode |= sIFSOCK
+	case fs.ModeDevice | fs.ModeCharDevice:
+		mode |= sIFCHR
+	default:
+		mode |= sIFREG
+	}
+
+	mtime := float64(info.ModTime().UnixNano()) / 1e6
+	size := info.Size()
+
+	return &jsObject{
+		properties: jsProperties{
+			"dev":     0,
+			"ino":     0,
+			"mode":    mode,
+			"nlink":   1,
+			"uid":     0,
+			"gid":     0,
+			"rdev":    0,
+			"size":    size,
+			"blksize": 4096,
+			"blocks":  (size + 511) / 512,
+			"atimeMs": mtime,
+			"mtimeMs": mtime,
+			"ctimeMs": mtime,
+
+			"isDirectory": newjsFunction(func([]any) any {
+				return info.IsDir()
+			}),
+		},
+	}
+}
+
+// fsOpen implements fs.open(path, flags, mode).
+func (mod *Module) fsOpen(args []any) (any, error) {
+	fsys, err := mod.filesys()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fsName(p)
+
+	var file fs.File
+	if flags&writeFlags != 0 {
+		wfs, ok := fsys.(WritableFS)
+		if !ok {
+			return nil, eROFS
+		}
+
+		perm, err := argInt(args, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err = wfs.OpenFile(name, int(flags&^oDIRECTORY), fs.FileMode(perm)&fs.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		file, err = fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&oDIRECTORY != 0 {
+		info, err := file.Stat()
+		if err == nil && !info.IsDir() {
+			err = eNOTDIR
+		}
+
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+
+	fd := mod.nextFD
+	mod.nextFD++
+	mod.files[fd] = &openFile{name: name, file: file}
+
+	mod.debug("   fs.open(path=%v fd=%v)", p, fd)
+
+	return fd, nil
+}
+
+// fsClose implements fs.close(fd).
+func (mod *Module) fsClose(args []any) (any, error) {
+	fd, err := argInt(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := mod.files[int(fd)]
+	if !ok {
+		return nil, eBADF
+	}
+
+	delete(mod.files, int(fd))
+	return nil, f.file.Close()
+}
+
+// fsRead implements fs.read(fd, buffer, offset, length, position). Reading
+// from standard input completes asynchronously.
+func (mod *Module) fsRead(args []any, done func(any, error)) {
+	fd, err := argInt(args, 0)
+	if err != nil {
+		done(nil, err)
+		return
+	}
+
+	if _, ok := mod.files[int(fd)]; !ok && fd == 0 {
+		mod.readStdin(args, done)
+		return
+	}
+
+	done(mod.readFile(args))
+}
+
+// readBuffer returns the part of the buffer specified by the buffer, offset
+// and length arguments of fs.read() and fs.write().
+func readBuffer(args []any) ([]byte, error) {
+	if len(args) < 4 {
+		return nil, eINVAL
+	}
+
+	buf, ok := args[1].(*jsUint8Array)
+	if !ok {
+		return nil, eINVAL
+	}
+
+	offset, err := argInt(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := argInt(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || length < 0 || offset+length > int64(len(buf.data)) {
+		return nil, eINVAL
+	}
+
+	return buf.data[offset : offset+length], nil
+}
+
+// readStdin reads from standard input through the instance. Because such a
+// read can block for a long time, it happens in the background while the
+// guest is free to run other goroutines or handle other events. Once the read
+// has completed, done is called with the guest locked.
+//
+// A read that is still blocked when the guest exits is left to complete on
+// its own, after which its result is discarded.
+//
+// This method must be called with the guest locked.
+func (mod *Module) readStdin(args []any, done func(any, error)) {
+	if mod.reader == nil {
+		done(nil, eNOSYS)
+		return
+	}
+
+	data, err := readBuffer(args)
+	if err != nil {
+		done(nil, err)
+		return
+	}
+
+	if len(args) > 4 && args[4] != nil {
+		done(nil, eSPIPE)
+		return
+	}
+
+	mod.pendingReads++
+
+	go func() {
+		buf := make([]byte, len(data))
+
+		mod.readMu.Lock()
+		n, err := mod.reader.Read(0, buf)
+		mod.readMu.Unlock()
+
+		if err := mod.lock(context.Background()); err != nil {
+			return
+		}
+		defer mod.unlock()
+
+		mod.pendingReads--
+		copy(data, buf[:n])
+
+		if n == 0 && err != nil && !errors.Is(err, io.EOF) {
+			done(nil, err)
+			return
+		}
+
+		done(n, nil)
+	}()
+}
+
+// readFile reads from a file that was opened by the guest.
+func (mod *Module) readFile(args []any) (any, error) {
+	f, err := mod.file(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readBuffer(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	switch {
+	// Without a position, read from the current position.
+	case len(args) < 5 || args[4] == nil:
+		n, err = f.file.Read(data)
+
+	default:
+		position, err := argInt(args, 4)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err = readAt(f.file, data, position)
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// fsWrite implements fs.write(fd, buffer, offset, length, position).
+func (mod *Module) fsWrite(args []any) (any, error) {
+	fd, err := argInt(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readBuffer(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stdout and stderr are handled by the instance.
+	if fd == 1 || fd == 2 {
+		if mod.writer == nil {
+			return nil, eNOSYS
+		}
+
+		return mod.write(int(fd), data)
+	}
+
+	f, ok := mod.files[int(fd)]
+	if !ok {
+		return nil, eBADF
+	}
+
+	// Without a position, write at the current position.
+	if len(args) < 5 || args[4] == nil {
+		w, ok := f.file.(io.Writer)
+		if !ok {
+			return nil, eBADF
+		}
+
+		return w.Write(data)
+	}
+
+	position, err := argInt(args, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	w, ok := f.file.(io.WriterAt)
+	if !ok {
+		return nil, eSPIPE
+	}
+
+	return w.WriteAt(data, position)
+}
+
+// readAt reads from the file at the specified position.
+func readAt(file fs.File, data []byte, position int64) (int, error) {
+	if r, ok := file.(io.ReaderAt); ok {
+		return r.ReadAt(data, position)
+	}
+
+	if s, ok := file.(io.Seeker); ok {
+		if _, err := s.Seek(position, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		return file.Read(data)
+	}
+
+	return 0, eSPIPE
+}
+
+// fsFstat implements fs.fstat(fd).
+func (mod *Module) fsFstat(args []any) (any, error) {
+	f, err := mod.file(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return statObject(info), nil
+}
+
+// lstat returns the file information of name without following a symbolic
+// link.
+func (mod *Module) lstat(name string) (fs.FileInfo, error) {
+	fsys, err := mod.filesys()
+	if err != nil {
+		return nil, err
+	}
+
+	if sfs, ok := fsys.(SymlinkFS); ok {
+		return sfs.Lstat(name)
+	}
+
+	return fs.Stat(fsys, name)
+}
+
+// fsStat implements fs.stat(path).
+func (mod *Module) fsStat(args []any) (any, error) {
+	fsys, err := mod.filesys()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fs.Stat(fsys, fsName(p))
+	if err != nil {
+		return nil, err
+	}
+
+	return statObject(info), nil
+}
+
+// fsLstat implements fs.lstat(path).
+func (mod *Module) fsLstat(args []any) (any, error) {
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := mod.lstat(fsName(p))
+	if err != nil {
+		return nil, err
+	}
+
+	return statObject(info), nil
+}
+
+// fsReaddir implements fs.readdir(path).
+func (mod *Module) fsReaddir(args []any) (any, error) {
+	fsys, err := mod.filesys()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(fsys, fsName(p))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]any, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+
+	return names, nil
+}
+
+// fsReadlink implements fs.readlink(path). A file system that does not
+// implement SymlinkFS has no notion of symbolic links, so any existing file
+// is not a link.
+func (mod *Module) fsReadlink(args []any) (any, error) {
+	fsys, err := mod.filesys()
+	if err != nil {
+		return nil, err
+	}
+
+	sfs, ok := fsys.(SymlinkFS)
+	if !ok {
+		if _, err := mod.fsStat(args); err != nil {
+			return nil, err
+		}
+
+		return nil, eINVAL
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return sfs.ReadLink(fsName(p))
+}
+
+// fsSymlink implements fs.symlink(target, path).
+func (mod *Module) fsSymlink(args []any) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	sfs, ok := wfs.(SymlinkFS)
+	if !ok {
+		return nil, eNOSYS
+	}
+
+	target, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, sfs.Symlink(target, fsName(p))
+}
+
+// fsMkdir implements fs.mkdir(path, perm).
+func (mod *Module) fsMkdir(args []any) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	perm, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, wfs.Mkdir(fsName(p), fs.FileMode(perm)&fs.ModePerm)
+}
+
+// fsRename implements fs.rename(from, to).
+func (mod *Module) fsRename(args []any) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := argString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, wfs.Rename(fsName(from), fsName(to))
+}
+
+// fsUnlink implements fs.unlink(path).
+func (mod *Module) fsUnlink(args []any) (any, error) {
+	return mod.remove(args, false)
+}
+
+// fsRmdir implements fs.rmdir(path).
+func (mod *Module) fsRmdir(args []any) (any, error) {
+	return mod.remove(args, true)
+}
+
+// remove removes a file or, if dir is true, an empty directory.
+func (mod *Module) remove(args []any, dir bool) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	name := fsName(p)
+
+	info, err := mod.lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case dir && !info.IsDir():
+		return nil, eNOTDIR
+	case !dir && info.IsDir():
+		return nil, eISDIR
+	}
+
+	return nil, wfs.Remove(name)
+}
+
+// fsTruncate implements fs.truncate(path, length).
+func (mod *Module) fsTruncate(args []any) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, wfs.Truncate(fsName(p), length)
+}
+
+// fsFtruncate implements fs.ftruncate(fd, length).
+func (mod *Module) fsFtruncate(args []any) (any, error) {
+	f, err := mod.file(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := f.file.(interface{ Truncate(size int64) error })
+	if !ok {
+		return nil, eINVAL
+	}
+
+	return nil, t.Truncate(length)
+}
+
+// fsFsync implements fs.fsync(fd).
+func (mod *Module) fsFsync(args []any) (any, error) {
+	f, err := mod.file(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := f.file.(interface{ Sync() error }); ok {
+		return nil, s.Sync()
+	}
+
+	return nil, nil
+}
+
+// fsChmod implements fs.chmod(path, mode).
+func (mod *Module) fsChmod(args []any) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, wfs.Chmod(fsName(p), fs.FileMode(mode)&fs.ModePerm)
+}
+
+// fsFchmod implements fs.fchmod(fd, mode).
+func (mod *Module) fsFchmod(args []any) (any, error) {
+	f, err := mod.file(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := f.file.(interface{ Chmod(mode fs.FileMode) error })
+	if !ok {
+		return nil, eROFS
+	}
+
+	return nil, c.Chmod(fs.FileMode(mode) & fs.ModePerm)
+}
+
+// fsUtimes implements fs.utimes(path, atime, mtime), where the times are in
+// seconds.
+func (mod *Module) fsUtimes(args []any) (any, error) {
+	wfs, err := mod.writableFS()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := argString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	atime, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	mtime, err := argInt(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, wfs.Chtimes(fsName(p), time.Unix(atime, 0), time.Unix(mtime, 0))
+}
+
+// fsUnsupported implements the functions that are not supported by any file
+// system, like changing ownership or creating links.
+func (mod *Module) fsUnsupported([]any) (any, error) {
+	if _, err := mod.writableFS(); err != nil {
+		return nil, err
+	}
+
+	return nil, eNOSYS
+}
@@ -0,0 +1,29 @@
+package wasmexec
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFSWriteArguments(t *testing.T) {
+	mod := newTestModule()
+	buf := &jsUint8Array{data: []byte("data")}
+
+	tests := []struct {
+		name string
+		args []any
+	}{
+		{name: "no buffer", args: []any{float64(1)}},
+		{name: "no length", args: []any{float64(1), buf, float64(0)}},
+		{name: "not a buffer", args: []any{float64(1), "data", float64(0), float64(4)}},
+		{name: "out of range", args: []any{float64(1), buf, float64(2), float64(4)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := mod.fsWrite(test.args); !errors.Is(err, eINVAL) {
+				t.Fatalf("fsWrite: got %v, want %v", err, eINVAL)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package wasmexec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Func is a host function that the guest is able to call. The guest receives
+// the returned value, converted like the value passed to SetGlobal, or has the
+// returned error thrown at it as an Error object. Returning an *Error throws an
+// object of the error type it names, like a TypeError.
+type Func func(args []Value) (any, error)
+
+// SetGlobal sets the property name of the global object to value, which makes
+// it available to the guest through js.Global().Get(name).
+//
+// The value can be a Value, nil, a bool, any kind of number, a string, a
+// []byte, which becomes a Uint8Array, a []any, which becomes an array, a
+// map[string]any, which becomes an object, or a Func. Slices and maps are
+// converted recursively. Any other value is converted as described by Bind.
+//
+// SetGlobal must not be called from within a Func, as that would deadlock.
+func (mod *Module) SetGlobal(name string, value any) error {
+	v, err := mod.toJS(value)
+	if err != nil {
+		return err
+	}
+
+	if err = mod.lock(context.Background()); err != nil {
+		return err
+	}
+	defer mod.unlock()
+
+	mod.global().properties[name] = v
+	return nil
+}
+
+// RegisterFunc makes fn available to the guest under path, which is a list of
+// property names separated by dots, starting at the global object. For
+// instance, registering "myapi.lookup" allows the guest to call
+// js.Global().Get("myapi").Call("lookup", "x"). Any objects along the path that
+// do not exist yet are created.
+//
+// RegisterFunc must not be called from within a Func, as that would deadlock.
+func (mod *Module) RegisterFunc(path string, fn Func) error {
+	return mod.register(path, func() (any, error) {
+		return mod.hostFunction(fn), nil
+	})
+}
+
+// register sets the property at path to the value returned by fn, which is
+// called with the guest locked. Any objects along the path that do not exist
+// yet are created.
+func (mod *Module) register(path string, fn func() (any, error)) error {
+	names := strings.Split(path, ".")
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("%s: invalid path", path)
+		}
+	}
+
+	if err := mod.lock(context.Background()); err != nil {
+		return err
+	}
+	defer mod.unlock()
+
+	obj := mod.global()
+	for i, name := range names[:len(names)-1] {
+		switch v := obj.properties[name].(type) {
+		case nil:
+			next := &jsObject{properties: make(jsProperties)}
+			obj.properties[name] = next
+			obj = next
+		case *jsObject:
+			obj = v
+		default:
+			return fmt.Errorf("%s: %T: not an object", strings.Join(names[:i+1], "."), v)
+		}
+	}
+
+	v, err := fn()
+	if err != nil {
+		return err
+	}
+
+	obj.properties[names[len(names)-1]] = v
+	return nil
+}
+
+// lookup returns the value of the property at path, which is a list of
+// property names separated by dots, starting at the global object.
+//
+// This method must be called with the guest locked.
+func (mod *Module) lookup(path string) (any, error) {
+	var v any = mod.global()
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := v.(*jsObject)
+		if !ok {
+			return nil, fmt.Errorf("%s: not found", path)
+		}
+
+		if v, ok = obj.properties[name]; !ok {
+			return nil, fmt.Errorf("%s: not found", path)
+		}
+	}
+
+	return v, nil
+}
+
+// Global returns the global object of the guest, like js.Global() does for
+// the guest. This allows the host to reach the objects that the guest has
+// published and to call their methods:
+//
+//	handlers := mod.Global().Get("myPlugin").Get("handlers")
+//	if _, err := handlers.Call("onEvent", "started"); err != nil {
+//		return err
+//	}
+//
+// The Value locks the guest whenever it is used, as do the values reached
+// from it. Global must not be called from within a Func, as that would
+// deadlock.
+func (mod *Module) Global() Value {
+	if mod.lock(context.Background()) == nil {
+		defer mod.unlock()
+	}
+
+	return Value{mod: mod, v: mod.global(), detached: true}
+}
+
+// global returns the global object.
+func (mod *Module) global() *jsObject {
+	return mod.values[5].(*jsObject)
+}
+
+// hostFunction returns a function that calls fn with the arguments of the
+// guest. If fn returns an error, or panics with a *ValueError because of an
+// argument of the wrong type, the error is thrown in the guest.
+func (mod *Module) hostFunction(fn Func) *jsFunction {
+	return &jsFunction{
+		fn: func(args []any) (result any) {
+			defer func() {
+				if r := recover(); r != nil {
+					valueErr, ok := r.(*ValueError)
+					if !ok {
+						panic(r)
+					}
+
+					result = &jsThrow{value: mod.newError("TypeError", valueErr.Error())}
+				}
+			}()
+
+			values := make([]Value, len(args))
+			for i, arg := range args {
+				values[i] = mod.value(arg)
+			}
+
+			v, err := fn(values)
+			if err == nil {
+				v, err = mod.toJS(v)
+			}
+			if err != nil {
+				return &jsThrow{value: mod.errorObject(err)}
+			}
+
+			return v
+		},
+	}
+}
+
+// toJS converts a Go value to a value that can be stored in the guest.
+func (mod *Module) toJS(v any) (any, error) {
+	return mod.convertToJS(v, newConversion())
+}
+
+// convertToJS converts a Go value as part of the conversion seen, which holds
+// the values that it is part of.
+func (mod *Module) convertToJS(v any, seen *conversion) (any, error) {
+	if num, ok := toFloat64(v); ok {
+		return num, nil
+	}
+
+	switch vv := v.(type) {
+	case nil, bool, string, []byte:
+		return vv, nil
+
+	case Value:
+		return vv.v, nil
+
+	case HostObject, *Promise:
+		return vv, nil
+
+	case []any:
+		leave, err := seen.visited.enter(reflect.ValueOf(vv))
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
+		elements := make([]any, len(vv))
+		for i, element := range vv {
+			if elements[i], err = mod.convertToJS(element, seen); err != nil {
+				return nil, err
+			}
+		}
+
+		return &jsArray{elements: elements}, nil
+
+	case map[string]any:
+		leave, err := seen.visited.enter(reflect.ValueOf(vv))
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
+		properties := make(jsProperties, len(vv))
+		for key, value := range vv {
+			if properties[key], err = mod.convertToJS(value, seen); err != nil {
+				return nil, err
+			}
+		}
+
+		return &jsObject{properties: properties}, nil
+
+	case Func:
+		return mod.hostFunction(vv), nil
+
+	case func(args []Value) (any, error):
+		return mod.hostFunction(vv), nil
+
+	default:
+		return mod.reflectToJS(reflect.ValueOf(v), seen)
+	}
+}
@@ -0,0 +1,92 @@
+package wasmexec
+
+import (
+	"reflect"
+	"strconv"
+)
+
+var hostObjectType = reflect.TypeOf((*HostObject)(nil)).Elem()
+
+// HostObject is an object whose properties are provided by the host on demand,
+// rather than stored up front. This allows the host to expose large or live
+// data sets to the guest, like a configuration tree or a database cursor.
+//
+// A HostObject is passed to the guest as is by SetGlobal, Bind and the return
+// values of host functions. The guest then reads, writes and deletes its
+// properties through js.Value.Get(), Set() and Delete(), with the index of
+// js.Value.Index() and SetIndex() formatted as a string. Values returned by Get
+// are converted like the value passed to SetGlobal. Set receives a bool,
+// float64, string, []byte, []any, map[string]any, HostObject or Value. An
+// object or array of the guest that holds itself can not be converted, and
+// setting one fails with a TypeError without calling Set.
+//
+// The methods of a HostObject are called with the guest locked, so they need
+// not be safe for concurrent use by the guest, but must not call into the
+// module. To keep the identity of the object in the guest, it should be
+// implemented by a pointer type.
+type HostObject interface {
+	// Get returns the value of the property key, or false if the property
+	// does not exist.
+	Get(key string) (any, bool)
+
+	// Set sets the property key to v.
+	Set(key string, v any) error
+
+	// Delete removes the property key.
+	Delete(key string) error
+
+	// Keys returns the names of all properties.
+	Keys() []string
+}
+
+// propertyKey returns the name of a property for a key passed by the guest.
+func propertyKey(key any) (string, bool) {
+	switch k := key.(type) {
+	case string:
+		return k, true
+	case int64:
+		return strconv.FormatInt(k, 10), true
+	default:
+		return "", false
+	}
+}
+
+// hostObjectGet returns the property key of obj, converted to a value that can
+// be stored in the guest. A property that does not exist is undefined.
+func (mod *Module) hostObjectGet(obj HostObject, key string) (any, error) {
+	v, ok := obj.Get(key)
+	if !ok {
+		return nil, nil
+	}
+
+	return mod.toJS(v)
+}
+
+// objectProperties returns the properties of an object, or false if v is not
+// an object.
+func (mod *Module) objectProperties(v any) (jsProperties, bool) {
+	switch obj := v.(type) {
+	case *jsObject:
+		return obj.properties, true
+
+	case jsProperties:
+		return obj, true
+
+	case HostObject:
+		keys := obj.Keys()
+		properties := make(jsProperties, len(keys))
+		for _, key := range keys {
+			value, err := mod.hostObjectGet(obj, key)
+			if err != nil {
+				continue
+			}
+
+			properties[key] = value
+		}
+
+		return properties, true
+
+	default:
+		return nil, false
+	}
+}
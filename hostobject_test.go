@@ -0,0 +1,94 @@
+package wasmexec
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testHostObject is a HostObject that holds its properties in a map.
+type testHostObject struct {
+	properties map[string]any
+}
+
+// Get implements HostObject.
+func (obj *testHostObject) Get(key string) (any, bool) {
+	v, ok := obj.properties[key]
+	return v, ok
+}
+
+// Set implements HostObject.
+func (obj *testHostObject) Set(key string, v any) error {
+	obj.properties[key] = v
+	return nil
+}
+
+// Delete implements HostObject.
+func (obj *testHostObject) Delete(key string) error {
+	delete(obj.properties, key)
+	return nil
+}
+
+// Keys implements HostObject.
+func (obj *testHostObject) Keys() []string {
+	keys := make([]string, 0, len(obj.properties))
+	for key := range obj.properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func TestHostObjectSet(t *testing.T) {
+	mod := newTestModule()
+
+	cyclic := &jsObject{properties: jsProperties{"name": "cyclic"}}
+	cyclic.properties["list"] = &jsArray{elements: []any{cyclic}}
+
+	shared := &jsObject{properties: jsProperties{"name": "shared"}}
+
+	tests := []struct {
+		name  string
+		key   any
+		value any
+		want  any
+		err   error
+	}{
+		{name: "number", key: "n", value: float64(1), want: float64(1)},
+		{name: "index", key: int64(2), value: "two", want: "two"},
+		{name: "array", key: "a", value: &jsArray{elements: []any{"x", nil}}, want: []any{"x", nil}},
+		{
+			name:  "shared object",
+			key:   "o",
+			value: &jsArray{elements: []any{shared, shared}},
+			want:  []any{map[string]any{"name": "shared"}, map[string]any{"name": "shared"}},
+		},
+		{name: "object that holds itself", key: "c", value: cyclic, err: errCircular},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := &testHostObject{properties: make(map[string]any)}
+
+			err := mod.reflectSet(obj, test.key, test.value)
+			switch {
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+				if len(obj.properties) != 0 {
+					t.Fatalf("Set was called with %v", obj.properties)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				name, _ := propertyKey(test.key)
+				if got := obj.properties[name]; !reflect.DeepEqual(got, test.want) {
+					t.Fatalf("got %#v, want %#v", got, test.want)
+				}
+			}
+		})
+	}
+}
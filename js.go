@@ -5,39 +5,53 @@ type errno string
 
 // This errno list is a subset of the errors in syscall/tables_js.go.
 const (
-	eNOSYS errno = "ENOSYS"
+	eACCES       errno = "EACCES"
+	eBADF        errno = "EBADF"
+	eBUSY        errno = "EBUSY"
+	eEXIST       errno = "EEXIST"
+	eFBIG        errno = "EFBIG"
+	eINVAL       errno = "EINVAL"
+	eIO          errno = "EIO"
+	eISDIR       errno = "EISDIR"
+	eLOOP        errno = "ELOOP"
+	eMFILE       errno = "EMFILE"
+	eNAMETOOLONG errno = "ENAMETOOLONG"
+	eNOENT       errno = "ENOENT"
+	eNOSPC       errno = "ENOSPC"
+	eNOSYS       errno = "ENOSYS"
+	eNOTDIR      errno = "ENOTDIR"
+	eNOTEMPTY    errno = "ENOTEMPTY"
+	ePERM        errno = "EPERM"
+	eROFS        errno = "EROFS"
+	eSPIPE       errno = "ESPIPE"
+	eXDEV        errno = "EXDEV"
 )
 
+// Error implements the error interface.
+func (code errno) Error() string {
+	return string(code)
+}
+
 // errorResponse returns a errno callback response.
 func errorResponse(code errno) []any {
 	return []any{jsProperties{"code": string(code)}}
 }
 
-// errCallback calls the callback with the specified errno code.
-func errorCallback(code errno) *jsFunction {
-	return &jsFunction{
-		fn: func(args []any) any {
-			if len(args) == 0 {
-				return nil
-			}
-
-			// The last item in the list should be a callback function, according to
-			// the fsCall() function in syscall/fs.js.go.
-			callback, ok := args[len(args)-1].(*jsFunction)
-			if !ok {
-				return nil
-			}
-
-			callback.fn(errorResponse(code))
-			return nil
-		},
-	}
-}
-
 // jsFunction describes the constructor of an jsObject.
 type jsFunction struct {
 	name string
 	fn   func(args []any) any
+
+	// method is called instead of fn if it is set, for a function that
+	// needs to know the value it is called on.
+	method func(this any, args []any) any
+
+	// properties holds the properties of the function itself, like the
+	// static methods of a constructor.
+	properties jsProperties
+
+	// extends is the constructor that this constructor extends, if any.
+	extends *jsFunction
 }
 
 // newjsFunction returns a new function.
@@ -45,6 +59,15 @@ func newjsFunction(fn func(args []any) any) *jsFunction {
 	return &jsFunction{fn: fn}
 }
 
+// call calls the function with this as its receiver.
+func (fn *jsFunction) call(this any, args []any) any {
+	if fn.method != nil {
+		return fn.method(this, args)
+	}
+
+	return fn.fn(args)
+}
+
 // Name returns the name of the constructor type.
 func (fn jsFunction) Name() string {
 	return fn.name
@@ -57,19 +80,52 @@ type jsProperties map[string]any
 // jsObject describes a JSON object.
 type jsObject struct {
 	properties jsProperties
+
+	// constructor is the function that created the object, if it is known.
+	constructor *jsFunction
 }
 
 // jsArray describes an array of elements.
 type jsArray struct {
 	elements []any
+
+	// properties holds the properties of the array other than its indices
+	// and its length. It is created when the first one is set.
+	properties jsProperties
 }
 
 // jsUint8Array describes a byte slice.
 type jsUint8Array struct {
 	data []byte
+
+	// buffer is the ArrayBuffer of which data is a view, starting at offset.
+	// It is created when it is first needed, for a Uint8Array that was
+	// created from a []byte.
+	buffer *jsArrayBuffer
+	offset int
 }
 
 // jsString represents a stored string.
 type jsString struct {
 	data string
 }
+
+// jsThrow is returned by the function of a jsFunction to throw value, rather
+// than to return it.
+type jsThrow struct {
+	value any
+}
+
+// Error implements the error interface.
+func (t *jsThrow) Error() string {
+	switch v := normalize(t.value).(type) {
+	case *jsObject:
+		if msg, ok := normalize(v.properties["message"]).(string); ok {
+			return msg
+		}
+	case string:
+		return v
+	}
+
+	return toString(t.value)
+}
@@ -0,0 +1,455 @@
+package wasmexec
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonObject returns the JSON object of the global object.
+func (mod *Module) jsonObject() *jsObject {
+	return &jsObject{
+		properties: jsProperties{
+			"parse": &jsFunction{
+				name: "parse",
+				fn: func(args []any) any {
+					return mod.jsonParse(toString(arg(args, 0)), arg(args, 1))
+				},
+			},
+			"stringify": &jsFunction{
+				name: "stringify",
+				fn: func(args []any) any {
+					return mod.jsonStringify(arg(args, 0), arg(args, 1), arg(args, 2))
+				},
+			},
+		},
+	}
+}
+
+// jsonParse parses text like JSON.parse. Objects become plain objects and
+// arrays become arrays. If reviver is a function, it is called for every value
+// from the inside out, and the value is replaced with its result.
+func (mod *Module) jsonParse(text string, reviver any) any {
+	// Numbers are decoded as strings, so that the numbers that are out of the
+	// range of a float64 become infinity, like in JavaScript. The syntax is
+	// checked first, because a decoder stops after the first value.
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+
+	var v any
+	err := json.Unmarshal([]byte(text), new(json.RawMessage))
+	if err == nil {
+		err = dec.Decode(&v)
+	}
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return &jsThrow{value: mod.newError("SyntaxError", "JSON.parse: "+syntaxErr.Error()+" at offset "+strconv.FormatInt(syntaxErr.Offset, 10))}
+		}
+		return &jsThrow{value: mod.newError("SyntaxError", "JSON.parse: "+err.Error())}
+	}
+
+	value := mod.jsonValue(v)
+
+	fn, ok := reviver.(*jsFunction)
+	if !ok {
+		return value
+	}
+
+	root := &jsObject{
+		constructor: mod.constructors["Object"],
+		properties:  jsProperties{"": value},
+	}
+
+	return revive(fn, root, "", value)
+}
+
+// jsonValue converts a value decoded by encoding/json.
+func (mod *Module) jsonValue(v any) any {
+	switch vv := v.(type) {
+	case map[string]any:
+		properties := make(jsProperties, len(vv))
+		for key, value := range vv {
+			properties[key] = mod.jsonValue(value)
+		}
+		return &jsObject{constructor: mod.constructors["Object"], properties: properties}
+
+	case json.Number:
+		f, err := strconv.ParseFloat(string(vv), 64)
+		if err != nil && !isRangeError(err) {
+			return NaN
+		}
+		return f
+
+	case []any:
+		elements := make([]any, len(vv))
+		for i, element := range vv {
+			elements[i] = mod.jsonValue(element)
+		}
+		return &jsArray{elements: elements}
+
+	default:
+		return v
+	}
+}
+
+// revive calls fn for the properties of value before value itself. Undefined
+// and null can not be told apart, so a property for which fn returns either is
+// removed from an object.
+func revive(fn *jsFunction, holder any, key string, value any) any {
+	switch v := value.(type) {
+	case *jsObject:
+		for _, name := range sortedKeys(v.properties) {
+			result := revive(fn, v, name, v.properties[name])
+			switch result.(type) {
+			case *jsThrow:
+				return result
+			case nil:
+				delete(v.properties, name)
+			default:
+				v.properties[name] = result
+			}
+		}
+
+	case *jsArray:
+		for i, element := range v.elements {
+			result := revive(fn, v, strconv.Itoa(i), element)
+			if _, ok := result.(*jsThrow); ok {
+				return result
+			}
+			v.elements[i] = result
+		}
+	}
+
+	return fn.call(holder, []any{key, value})
+}
+
+// jsonStringify formats v like JSON.stringify. The replacer is either a
+// function that is called for every value to replace it, or an array with
+// the names of the properties to include. The space is either the number of
+// spaces, or the string to indent with.
+func (mod *Module) jsonStringify(v, replacer, space any) any {
+	s := &jsonStringifier{mod: mod}
+
+	switch r := normalize(replacer).(type) {
+	case *jsFunction:
+		s.replacer = r
+	case *jsArray:
+		s.keys = []string{}
+		for _, element := range r.elements {
+			switch key := normalize(element).(type) {
+			case string, float64:
+				s.keys = append(s.keys, toString(key))
+			}
+		}
+	}
+
+	switch sp := normalize(space).(type) {
+	case float64:
+		s.gap = strings.Repeat(" ", int(math.Max(0, math.Min(10, sp))))
+	case string:
+		s.gap = sp
+		if len(utf16Of(sp)) > 10 {
+			s.gap = stringOf(utf16Of(sp)[:10])
+		}
+	}
+
+	root := &jsObject{
+		constructor: mod.constructors["Object"],
+		properties:  jsProperties{"": v},
+	}
+
+	ok, err := s.write(root, "", v, "")
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return nil
+	}
+
+	return s.b.String()
+}
+
+// jsonStringifier holds the state of a JSON.stringify call.
+type jsonStringifier struct {
+	mod *Module
+	b   strings.Builder
+
+	replacer *jsFunction
+	keys     []string
+	gap      string
+
+	// stack holds the objects that are being written, to detect cycles.
+	stack []uintptr
+}
+
+// write writes the value of the property key of holder. It returns false if
+// the value is not written at all, which is the case for functions.
+func (s *jsonStringifier) write(holder any, key string, v any, indent string) (bool, *jsThrow) {
+	v = normalize(v)
+
+	switch vv := v.(type) {
+	case *jsObject:
+		if toJSON, ok := vv.properties["toJSON"].(*jsFunction); ok {
+			v = toJSON.call(vv, []any{key})
+		}
+	case *jsDate:
+		if vv.valid() {
+			v = vv.isoString()
+		} else {
+			v = nil
+		}
+	}
+	if s.replacer != nil {
+		v = s.replacer.call(holder, []any{key, v})
+	}
+	if t, ok := v.(*jsThrow); ok {
+		return false, t
+	}
+
+	switch vv := normalize(v).(type) {
+	case nil:
+		s.b.WriteString("null")
+	case bool:
+		s.b.WriteString(strconv.FormatBool(vv))
+	case float64:
+		if math.IsNaN(vv) || math.IsInf(vv, 0) {
+			s.b.WriteString("null")
+		} else {
+			s.b.WriteString(formatNumber(vv))
+		}
+	case string:
+		writeJSONString(&s.b, vv)
+	case *jsFunction:
+		return false, nil
+
+	case *jsArray:
+		return true, s.writeArray(vv, indent)
+
+	case typedArray:
+		properties := make(jsProperties, typedArrayLength(vv))
+		for i := 0; i < typedArrayLength(vv); i++ {
+			properties[strconv.Itoa(i)], _ = typedArrayIndex(vv, i)
+		}
+		return true, s.writeObject(vv, properties, indent)
+
+	case *jsObject:
+		return true, s.writeObject(vv, vv.properties, indent)
+
+	case jsProperties:
+		return true, s.writeObject(vv, vv, indent)
+
+	case HostObject:
+		properties := make(jsProperties)
+		for _, name := range vv.Keys() {
+			value, err := s.mod.hostObjectGet(vv, name)
+			if err != nil {
+				return false, &jsThrow{value: s.mod.errorObject(err)}
+			}
+			properties[name] = value
+		}
+		return true, s.writeObject(vv, properties, indent)
+
+	default:
+		s.b.WriteString("{}")
+	}
+
+	return true, nil
+}
+
+// writeArray writes the elements of an array, where an element that can not
+// be written is written as null.
+func (s *jsonStringifier) writeArray(a *jsArray, indent string) *jsThrow {
+	if t := s.push(a); t != nil {
+		return t
+	}
+	defer s.pop()
+
+	if len(a.elements) == 0 {
+		s.b.WriteString("[]")
+		return nil
+	}
+
+	inner := indent + s.gap
+	s.b.WriteByte('[')
+	for i, element := range a.elements {
+		if i > 0 {
+			s.b.WriteByte(',')
+		}
+		s.newline(inner)
+
+		ok, t := s.write(a, strconv.Itoa(i), element, inner)
+		if t != nil {
+			return t
+		}
+		if !ok {
+			s.b.WriteString("null")
+		}
+	}
+	s.newline(indent)
+	s.b.WriteByte(']')
+
+	return nil
+}
+
+// writeObject writes the properties of an object, leaving out the ones that
+// can not be written.
+func (s *jsonStringifier) writeObject(obj any, properties jsProperties, indent string) *jsThrow {
+	if t := s.push(obj); t != nil {
+		return t
+	}
+	defer s.pop()
+
+	keys := s.keys
+	if keys == nil {
+		keys = sortedKeys(properties)
+	}
+
+	inner := indent + s.gap
+	s.b.WriteByte('{')
+	empty := true
+	for _, key := range keys {
+		value, ok := properties[key]
+		if !ok {
+			continue
+		}
+
+		// Write the key speculatively and take it back if the value turns
+		// out to be left out.
+		mark := s.b.Len()
+		if !empty {
+			s.b.WriteByte(',')
+		}
+		s.newline(inner)
+		writeJSONString(&s.b, key)
+		s.b.WriteByte(':')
+		if s.gap != "" {
+			s.b.WriteByte(' ')
+		}
+
+		written, t := s.write(obj, key, value, inner)
+		if t != nil {
+			return t
+		}
+		if !written {
+			str := s.b.String()[:mark]
+			s.b.Reset()
+			s.b.WriteString(str)
+			continue
+		}
+		empty = false
+	}
+	if !empty {
+		s.newline(indent)
+	}
+	s.b.WriteByte('}')
+
+	return nil
+}
+
+// newline starts a new line with the specified indentation, if there is a
+// gap to indent with.
+func (s *jsonStringifier) newline(indent string) {
+	if s.gap != "" {
+		s.b.WriteByte('\n')
+		s.b.WriteString(indent)
+	}
+}
+
+// push adds obj to the objects that are being written, or returns a TypeError
+// if it is being written already.
+func (s *jsonStringifier) push(obj any) *jsThrow {
+	ptr := reflect.ValueOf(obj)
+	if ptr.Kind() != reflect.Ptr && ptr.Kind() != reflect.Map {
+		s.stack = append(s.stack, 0)
+		return nil
+	}
+
+	for _, p := range s.stack {
+		if p == ptr.Pointer() {
+			return &jsThrow{value: s.mod.newError("TypeError", "Converting circular structure to JSON")}
+		}
+	}
+	s.stack = append(s.stack, ptr.Pointer())
+
+	return nil
+}
+
+// pop removes the last object that was pushed.
+func (s *jsonStringifier) pop() {
+	s.stack = s.stack[:len(s.stack)-1]
+}
+
+// sortedKeys returns the keys of properties in the order of JavaScript, which
+// puts the array indices first in ascending order. Other keys are sorted, as
+// the order in which they were added is not known.
+func sortedKeys(properties jsProperties) []string {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, aIndex := arrayIndex(keys[i])
+		b, bIndex := arrayIndex(keys[j])
+		switch {
+		case aIndex && bIndex:
+			return a < b
+		case aIndex != bIndex:
+			return aIndex
+		default:
+			return keys[i] < keys[j]
+		}
+	})
+
+	return keys
+}
+
+// arrayIndex returns the array index that key represents, if it does.
+func arrayIndex(key string) (uint32, bool) {
+	i, err := strconv.ParseUint(key, 10, 32)
+	if err != nil || strconv.FormatUint(i, 10) != key || i == math.MaxUint32 {
+		return 0, false
+	}
+
+	return uint32(i), true
+}
+
+// writeJSONString writes s as a JSON string, escaped like JSON.stringify does.
+// Unlike encoding/json, it leaves <, > and & as they are.
+func writeJSONString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteByte("0123456789abcdef"[r>>4])
+			b.WriteByte("0123456789abcdef"[r&0xF])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,1024 @@
+package wasmexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxSymlinks is the maximum number of symbolic links that are followed when
+// looking up a name, after which the lookup fails with ELOOP.
+const maxSymlinks = 40
+
+// maxFileSize is the maximum size of a file in a MemFS, regardless of any
+// quota. Growing a file beyond it fails with EFBIG, so that a single write or
+// truncate by the guest never makes the host allocate more than this.
+const maxFileSize = 1 << 30
+
+// defaultMaxSize is the quota on the total size of all files that a MemFS
+// starts out with.
+const defaultMaxSize = 1 << 30
+
+// MemFS is an in-memory file system with directories, regular files and
+// symbolic links. It implements WritableFS and SymlinkFS, so it can be given
+// to the guest as is or mounted through Mounts.
+//
+// Permissions are enforced as if the guest is the owner of every file, so a
+// file without the 0400 bit can not be opened for reading and a file without
+// the 0200 bit can not be opened for writing. Adding or removing an entry
+// requires the 0200 bit on its directory.
+//
+// The target of a symbolic link that is an absolute path is resolved from
+// the root of the MemFS and never leaves it.
+type MemFS struct {
+	mu   sync.Mutex
+	root *memNode
+
+	maxSize   int64
+	maxInodes int
+	size      int64
+	inodes    int
+}
+
+// memNode is a directory, regular file or symbolic link in a MemFS.
+type memNode struct {
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte              // The contents of a regular file.
+	target   string              // The target of a symbolic link.
+	children map[string]*memNode // The entries of a directory.
+}
+
+// NewMemFS returns an empty MemFS. The total size of all files is limited to
+// 1 GiB, which SetQuota changes, and the number of inodes is not limited.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		root:    newMemNode(fs.ModeDir | 0o755),
+		maxSize: defaultMaxSize,
+		inodes:  1,
+	}
+}
+
+// newMemNode returns a new node with the specified mode.
+func newMemNode(mode fs.FileMode) *memNode {
+	n := &memNode{mode: mode, modTime: time.Now()}
+	if mode.IsDir() {
+		n.children = make(map[string]*memNode)
+	}
+
+	return n
+}
+
+// SetQuota limits the total size of all files in bytes and the number of
+// files, directories and symbolic links. A value of 0 or less means that
+// there is no limit. Changes that exceed a quota fail with ENOSPC. Without a
+// limit on the total size, a single file still can not grow beyond 1 GiB.
+func (m *MemFS) SetQuota(maxSize int64, maxInodes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxSize = maxSize
+	m.maxInodes = maxInodes
+}
+
+// Usage returns the total size of all files in bytes and the number of files,
+// directories and symbolic links, including the root directory.
+func (m *MemFS) Usage() (size int64, inodes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.size, m.inodes
+}
+
+// reserve accounts for size extra bytes and inodes extra nodes, or returns
+// ENOSPC if that exceeds a quota. Negative values release space.
+func (m *MemFS) reserve(size int64, inodes int) error {
+	if size > 0 && m.maxSize > 0 && m.size+size > m.maxSize {
+		return syscall.ENOSPC
+	}
+	if inodes > 0 && m.maxInodes > 0 && m.inodes+inodes > m.maxInodes {
+		return syscall.ENOSPC
+	}
+
+	m.size += size
+	m.inodes += inodes
+	return nil
+}
+
+// release gives back the space used by n and everything below it.
+func (m *MemFS) release(n *memNode) {
+	size, inodes := n.usage()
+	m.size -= size
+	m.inodes -= inodes
+}
+
+// usage returns the space used by n and everything below it.
+func (n *memNode) usage() (size int64, inodes int) {
+	size, inodes = int64(len(n.data)), 1
+	for _, child := range n.children {
+		s, i := child.usage()
+		size += s
+		inodes += i
+	}
+
+	return size, inodes
+}
+
+// contains returns true if dir is n or one of the directories below it.
+func (n *memNode) contains(dir *memNode) bool {
+	if n == dir {
+		return true
+	}
+
+	for _, child := range n.children {
+		if child.mode.IsDir() && child.contains(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// memEntry is the result of looking up a name in a MemFS.
+type memEntry struct {
+	dir  *memNode // The directory that contains the node, nil for the root.
+	name string   // The name of the node in dir.
+	node *memNode // The node itself, nil if it does not exist.
+}
+
+// memPathError returns a *fs.PathError.
+func memPathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// lookup finds name in the file system. Symbolic links in the directories
+// leading up to name are always followed, a symbolic link in the last
+// element only if follow is true. If the last element does not exist, the
+// returned entry has no node.
+func (m *MemFS) lookup(op, name string, follow bool) (memEntry, error) {
+	if !fs.ValidPath(name) {
+		return memEntry{}, memPathError(op, name, fs.ErrInvalid)
+	}
+
+	dirs := []*memNode{m.root}
+	parts := strings.Split(name, "/")
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			continue
+		}
+
+		dir := dirs[len(dirs)-1]
+		if !dir.mode.IsDir() {
+			return memEntry{}, memPathError(op, name, syscall.ENOTDIR)
+		}
+
+		child, ok := dir.children[part]
+		switch {
+		case !ok && len(parts) == 0:
+			return memEntry{dir: dir, name: part}, nil
+		case !ok:
+			return memEntry{}, memPathError(op, name, syscall.ENOENT)
+		case child.mode&fs.ModeSymlink != 0 && (follow || len(parts) > 0):
+			if links++; links > maxSymlinks {
+				return memEntry{}, memPathError(op, name, syscall.ELOOP)
+			}
+			if path.IsAbs(child.target) {
+				dirs = dirs[:1]
+			}
+			parts = append(strings.Split(child.target, "/"), parts...)
+		case len(parts) == 0:
+			return memEntry{dir: dir, name: part, node: child}, nil
+		default:
+			dirs = append(dirs, child)
+		}
+	}
+
+	// The name resolved to a directory through "." or "..", which is not an
+	// entry that can be added or removed.
+	return memEntry{name: ".", node: dirs[len(dirs)-1]}, nil
+}
+
+// lookupNode finds the node for name, or returns ENOENT if it does not exist.
+func (m *MemFS) lookupNode(op, name string, follow bool) (*memNode, error) {
+	e, err := m.lookup(op, name, follow)
+	if err != nil {
+		return nil, err
+	}
+	if e.node == nil {
+		return nil, memPathError(op, name, syscall.ENOENT)
+	}
+
+	return e.node, nil
+}
+
+// writable returns EACCES if entries can not be added to or removed from the
+// directory dir.
+func writable(op, name string, dir *memNode) error {
+	if dir != nil && dir.mode&0o200 == 0 {
+		return memPathError(op, name, syscall.EACCES)
+	}
+
+	return nil
+}
+
+// create adds a new node with the specified mode to the directory of e.
+func (m *MemFS) create(op, name string, e memEntry, mode fs.FileMode) (*memNode, error) {
+	if e.dir == nil {
+		return nil, memPathError(op, name, syscall.EEXIST)
+	}
+	if err := m.reserve(0, 1); err != nil {
+		return nil, memPathError(op, name, err)
+	}
+
+	n := newMemNode(mode)
+	e.dir.children[e.name] = n
+	e.dir.modTime = n.modTime
+	return n, nil
+}
+
+// resize changes the size of the contents of n.
+func (m *MemFS) resize(n *memNode, size int64) error {
+	switch {
+	case size < 0:
+		return syscall.EINVAL
+	case size > maxFileSize:
+		return syscall.EFBIG
+	}
+	if err := m.reserve(size-int64(len(n.data)), 0); err != nil {
+		return err
+	}
+
+	if size <= int64(cap(n.data)) {
+		old := int64(len(n.data))
+		n.data = n.data[:size]
+		for i := old; i < size; i++ {
+			n.data[i] = 0
+		}
+	} else {
+		data := make([]byte, size)
+		copy(data, n.data)
+		n.data = data
+	}
+
+	n.modTime = time.Now()
+	return nil
+}
+
+// Open implements fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, syscall.O_RDONLY, 0)
+}
+
+// OpenFile implements WritableFS.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup("open", name, flag&(syscall.O_CREAT|syscall.O_EXCL) != syscall.O_CREAT|syscall.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &memFile{fsys: m, node: e.node, name: path.Base(name), flag: flag}
+
+	switch {
+	case e.node == nil && flag&syscall.O_CREAT == 0:
+		return nil, memPathError("open", name, syscall.ENOENT)
+	case e.node == nil:
+		// A file that is created is always opened with the requested access,
+		// regardless of its permissions.
+		if err := writable("open", name, e.dir); err != nil {
+			return nil, err
+		}
+		if f.node, err = m.create("open", name, e, perm&fs.ModePerm); err != nil {
+			return nil, err
+		}
+
+		return f, nil
+	case flag&(syscall.O_CREAT|syscall.O_EXCL) == syscall.O_CREAT|syscall.O_EXCL:
+		return nil, memPathError("open", name, syscall.EEXIST)
+	}
+
+	n := e.node
+	write := flag&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	switch {
+	case n.mode.IsDir() && write:
+		return nil, memPathError("open", name, syscall.EISDIR)
+	case write && n.mode&0o200 == 0, flag&syscall.O_WRONLY == 0 && n.mode&0o400 == 0:
+		return nil, memPathError("open", name, syscall.EACCES)
+	}
+
+	if write && flag&syscall.O_TRUNC != 0 {
+		if err := m.resize(n, 0); err != nil {
+			return nil, memPathError("open", name, err)
+		}
+	}
+
+	return f, nil
+}
+
+// Stat implements fs.StatFS.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.info(path.Base(name)), nil
+}
+
+// Lstat implements SymlinkFS.
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.info(path.Base(name)), nil
+}
+
+// ReadLink implements SymlinkFS.
+func (m *MemFS) ReadLink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", memPathError("readlink", name, syscall.EINVAL)
+	}
+
+	return n.target, nil
+}
+
+// Symlink implements SymlinkFS.
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup("symlink", newname, false)
+	if err != nil {
+		return err
+	}
+	if e.node != nil {
+		return memPathError("symlink", newname, syscall.EEXIST)
+	}
+	if err := writable("symlink", newname, e.dir); err != nil {
+		return err
+	}
+
+	n, err := m.create("symlink", newname, e, fs.ModeSymlink|0o777)
+	if err != nil {
+		return err
+	}
+
+	n.target = oldname
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, memPathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	return n.entries(), nil
+}
+
+// Mkdir implements WritableFS.
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	if e.node != nil {
+		return memPathError("mkdir", name, syscall.EEXIST)
+	}
+	if err := writable("mkdir", name, e.dir); err != nil {
+		return err
+	}
+
+	_, err = m.create("mkdir", name, e, fs.ModeDir|perm&fs.ModePerm)
+	return err
+}
+
+// Rename implements WritableFS.
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, err := m.lookup("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	if src.node == nil {
+		return memPathError("rename", oldname, syscall.ENOENT)
+	}
+
+	dst, err := m.lookup("rename", newname, false)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case src.dir == nil || dst.dir == nil:
+		return memPathError("rename", newname, syscall.EBUSY)
+	case src.dir.mode&0o200 == 0 || dst.dir.mode&0o200 == 0:
+		return memPathError("rename", newname, syscall.EACCES)
+	case src.node == dst.node:
+		return nil
+	case src.node.mode.IsDir() && src.node.contains(dst.dir):
+		return memPathError("rename", newname, syscall.EINVAL)
+	}
+
+	if dst.node != nil {
+		switch {
+		case src.node.mode.IsDir() && !dst.node.mode.IsDir():
+			return memPathError("rename", newname, syscall.ENOTDIR)
+		case !src.node.mode.IsDir() && dst.node.mode.IsDir():
+			return memPathError("rename", newname, syscall.EISDIR)
+		case len(dst.node.children) > 0:
+			return memPathError("rename", newname, syscall.ENOTEMPTY)
+		}
+
+		m.release(dst.node)
+	}
+
+	now := time.Now()
+	delete(src.dir.children, src.name)
+	dst.dir.children[dst.name] = src.node
+	src.dir.modTime = now
+	dst.dir.modTime = now
+	return nil
+}
+
+// Remove implements WritableFS.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case e.node == nil:
+		return memPathError("remove", name, syscall.ENOENT)
+	case e.dir == nil:
+		return memPathError("remove", name, syscall.EBUSY)
+	case len(e.node.children) > 0:
+		return memPathError("remove", name, syscall.ENOTEMPTY)
+	}
+
+	if err := writable("remove", name, e.dir); err != nil {
+		return err
+	}
+
+	m.release(e.node)
+	delete(e.dir.children, e.name)
+	e.dir.modTime = time.Now()
+	return nil
+}
+
+// Truncate implements WritableFS.
+func (m *MemFS) Truncate(name string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("truncate", name, true)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case n.mode.IsDir():
+		return memPathError("truncate", name, syscall.EISDIR)
+	case n.mode&0o200 == 0:
+		return memPathError("truncate", name, syscall.EACCES)
+	}
+
+	if err := m.resize(n, size); err != nil {
+		return memPathError("truncate", name, err)
+	}
+
+	return nil
+}
+
+// Chmod implements WritableFS.
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("chmod", name, true)
+	if err != nil {
+		return err
+	}
+
+	n.mode = n.mode.Type() | mode&fs.ModePerm
+	return nil
+}
+
+// Chtimes implements WritableFS. Only the modification time is kept.
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookupNode("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+
+	n.modTime = mtime
+	return nil
+}
+
+// ImportTar adds the directories, regular files and symbolic links of the
+// tar archive read from r to the file system, replacing any existing files
+// with the same name. Hard links are imported as copies of their target and
+// all other types of entries are skipped. The quotas apply to the import.
+func (m *MemFS) ImportTar(r io.Reader) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			if hdr.Typeflag == tar.TypeDir {
+				m.root.mode = fs.ModeDir | fs.FileMode(hdr.Mode)&fs.ModePerm
+				m.root.modTime = hdr.ModTime
+			}
+			continue
+		}
+
+		if err := m.mkdirAll(path.Dir(name)); err != nil {
+			return err
+		}
+
+		var n *memNode
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			n, err = m.importNode(name, fs.ModeDir)
+		case tar.TypeReg:
+			if n, err = m.importNode(name, 0); err == nil {
+				err = m.importData(n, tr, hdr.Size)
+			}
+		case tar.TypeLink:
+			var target *memNode
+			if target, err = m.lookupNode("link", strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/"), true); err == nil {
+				data := append([]byte(nil), target.data...)
+				if n, err = m.importNode(name, 0); err == nil {
+					err = m.importData(n, bytes.NewReader(data), int64(len(data)))
+				}
+			}
+		case tar.TypeSymlink:
+			if n, err = m.importNode(name, fs.ModeSymlink); err == nil {
+				n.target = hdr.Linkname
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if n.mode&fs.ModeSymlink == 0 {
+			n.mode = n.mode.Type() | fs.FileMode(hdr.Mode)&fs.ModePerm
+		}
+		n.modTime = hdr.ModTime
+	}
+}
+
+// mkdirAll creates the directory name and any parents that do not exist yet.
+func (m *MemFS) mkdirAll(name string) error {
+	if name == "." {
+		return nil
+	}
+
+	e, err := m.lookup("mkdir", name, true)
+	if err != nil {
+		if err := m.mkdirAll(path.Dir(name)); err != nil {
+			return err
+		}
+		if e, err = m.lookup("mkdir", name, true); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case e.node == nil:
+		_, err = m.create("mkdir", name, e, fs.ModeDir|0o755)
+		return err
+	case !e.node.mode.IsDir():
+		return memPathError("mkdir", name, syscall.ENOTDIR)
+	default:
+		return nil
+	}
+}
+
+// importNode returns a node of the specified type for name. An existing node
+// of the same type is reused, except for a symbolic link.
+func (m *MemFS) importNode(name string, typ fs.FileMode) (*memNode, error) {
+	e, err := m.lookup("import", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.node != nil {
+		if e.node.mode.Type() == typ && typ != fs.ModeSymlink {
+			return e.node, nil
+		}
+		if len(e.node.children) > 0 {
+			return nil, memPathError("import", name, syscall.ENOTEMPTY)
+		}
+
+		m.release(e.node)
+		delete(e.dir.children, e.name)
+	}
+
+	return m.create("import", name, e, typ|0o700)
+}
+
+// importData replaces the contents of n with size bytes read from r.
+func (m *MemFS) importData(n *memNode, r io.Reader, size int64) error {
+	if err := m.resize(n, 0); err != nil {
+		return err
+	}
+	if err := m.resize(n, size); err != nil {
+		return err
+	}
+
+	_, err := io.ReadFull(r, n.data)
+	return err
+}
+
+// ExportTar writes all directories, regular files and symbolic links in the
+// file system to w as a tar archive.
+func (m *MemFS) ExportTar(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tw := tar.NewWriter(w)
+	if err := m.root.export(tw, ""); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// export writes the entries of the directory n to tw, in lexical order.
+func (n *memNode) export(tw *tar.Writer, dir string) error {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := n.children[name]
+		hdr := &tar.Header{
+			Name:    dir + name,
+			Mode:    int64(child.mode.Perm()),
+			ModTime: child.modTime,
+			Format:  tar.FormatPAX,
+		}
+
+		switch child.mode.Type() {
+		case fs.ModeDir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case fs.ModeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = child.target
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(child.data))
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		switch child.mode.Type() {
+		case fs.ModeDir:
+			if err := child.export(tw, hdr.Name); err != nil {
+				return err
+			}
+		case 0:
+			if _, err := tw.Write(child.data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// entries returns the entries of the directory n, sorted by name.
+func (n *memNode) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for name, child := range n.children {
+		entries = append(entries, child.info(name))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries
+}
+
+// info returns a snapshot of the file information of n.
+func (n *memNode) info(name string) *memFileInfo {
+	size := int64(len(n.data))
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+
+	return &memFileInfo{name: name, size: size, mode: n.mode, modTime: n.modTime}
+}
+
+// memFileInfo implements fs.FileInfo and fs.DirEntry for a node.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (info *memFileInfo) Name() string               { return info.name }
+func (info *memFileInfo) Size() int64                { return info.size }
+func (info *memFileInfo) Mode() fs.FileMode          { return info.mode }
+func (info *memFileInfo) ModTime() time.Time         { return info.modTime }
+func (info *memFileInfo) IsDir() bool                { return info.mode.IsDir() }
+func (info *memFileInfo) Sys() any                   { return nil }
+func (info *memFileInfo) Type() fs.FileMode          { return info.mode.Type() }
+func (info *memFileInfo) Info() (fs.FileInfo, error) { return info, nil }
+
+// memFile is an open file of a MemFS.
+type memFile struct {
+	fsys   *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	dirPos int
+	closed bool
+}
+
+// check returns an error if the file is closed or if it was not opened for
+// the specified access.
+func (f *memFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return memPathError(op, f.name, fs.ErrClosed)
+	case write && f.flag&(syscall.O_WRONLY|syscall.O_RDWR) == 0,
+		!write && f.flag&syscall.O_WRONLY != 0:
+		return memPathError(op, f.name, syscall.EBADF)
+	case f.node.mode.IsDir():
+		return memPathError(op, f.name, syscall.EISDIR)
+	}
+
+	return nil
+}
+
+// Stat implements fs.File.
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return nil, memPathError("stat", f.name, fs.ErrClosed)
+	}
+
+	return f.node.info(f.name), nil
+}
+
+// Read implements fs.File.
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	n, err := f.readAt(b, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	n, err := f.readAt(b, off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// readAt reads from the contents of the file at offset off.
+func (f *memFile) readAt(b []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, memPathError("read", f.name, syscall.EINVAL)
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+
+	return copy(b, f.node.data[off:]), nil
+}
+
+// Write implements io.Writer.
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.flag&syscall.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+
+	n, err := f.writeAt(b, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// WriteAt implements io.WriterAt.
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	return f.writeAt(b, off)
+}
+
+// writeAt writes to the contents of the file at offset off.
+func (f *memFile) writeAt(b []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	switch {
+	case off < 0:
+		return 0, memPathError("write", f.name, syscall.EINVAL)
+	case off > maxFileSize:
+		return 0, memPathError("write", f.name, syscall.EFBIG)
+	}
+
+	if end := off + int64(len(b)); end > int64(len(f.node.data)) {
+		if err := f.fsys.resize(f.node, end); err != nil {
+			return 0, memPathError("write", f.name, err)
+		}
+	}
+
+	f.node.modTime = time.Now()
+	return copy(f.node.data[off:], b), nil
+}
+
+// Seek implements io.Seeker.
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return 0, memPathError("seek", f.name, fs.ErrClosed)
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, memPathError("seek", f.name, syscall.EINVAL)
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (f *memFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return nil, memPathError("readdir", f.name, fs.ErrClosed)
+	case !f.node.mode.IsDir():
+		return nil, memPathError("readdir", f.name, syscall.ENOTDIR)
+	}
+
+	entries := f.node.entries()
+	if f.dirPos > len(entries) {
+		f.dirPos = len(entries)
+	}
+	entries = entries[f.dirPos:]
+
+	if count > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if len(entries) > count {
+			entries = entries[:count]
+		}
+	}
+
+	f.dirPos += len(entries)
+	return entries, nil
+}
+
+// Truncate changes the size of the file.
+func (f *memFile) Truncate(size int64) error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+
+	if err := f.fsys.resize(f.node, size); err != nil {
+		return memPathError("truncate", f.name, err)
+	}
+
+	return nil
+}
+
+// Chmod changes the permissions of the file.
+func (f *memFile) Chmod(mode fs.FileMode) error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return memPathError("chmod", f.name, fs.ErrClosed)
+	}
+
+	f.node.mode = f.node.mode.Type() | mode&fs.ModePerm
+	return nil
+}
+
+// Sync implements the Sync() method of *os.File, which has nothing to do for
+// a file in memory.
+func (f *memFile) Sync() error {
+	return nil
+}
+
+// Close implements fs.File.
+func (f *memFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return memPathError("close", f.name, fs.ErrClosed)
+	}
+
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,290 @@
+package wasmexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemFSSizeLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		fn      func(m *MemFS) error
+		err     error
+	}{
+		{
+			name: "truncate",
+			fn:   func(m *MemFS) error { return m.Truncate("file", 1<<20) },
+		},
+		{
+			name: "truncate with the default quota",
+			fn:   func(m *MemFS) error { return m.Truncate("file", 1<<40) },
+			err:  syscall.EFBIG,
+		},
+		{
+			name:    "truncate beyond the quota",
+			maxSize: 1 << 10,
+			fn:      func(m *MemFS) error { return m.Truncate("file", 1<<10+1) },
+			err:     syscall.ENOSPC,
+		},
+		{
+			name:    "truncate beyond the maximum file size",
+			maxSize: -1,
+			fn:      func(m *MemFS) error { return m.Truncate("file", 1<<40) },
+			err:     syscall.EFBIG,
+		},
+		{
+			name:    "write beyond the maximum file size",
+			maxSize: -1,
+			fn: func(m *MemFS) error {
+				return writeFileAt(m, "file", []byte("data"), maxFileSize-2)
+			},
+			err: syscall.EFBIG,
+		},
+		{
+			name:    "write at the largest offset",
+			maxSize: -1,
+			fn: func(m *MemFS) error {
+				return writeFileAt(m, "file", []byte("data"), 1<<63-1)
+			},
+			err: syscall.EFBIG,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMemFS()
+			if test.maxSize != 0 {
+				m.SetQuota(test.maxSize, 0)
+			}
+			if err := writeFile(m, "file", "data"); err != nil {
+				t.Fatal(err)
+			}
+
+			err := test.fn(m)
+			switch {
+			case test.err == nil && err != nil:
+				t.Fatalf("got %v", err)
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+
+			// A failed change leaves the file and the usage as they were.
+			if size, _ := m.Usage(); test.err != nil && size != 4 {
+				t.Fatalf("Usage: got a size of %d, want 4", size)
+			}
+		})
+	}
+}
+
+func TestMemFSDefaultQuota(t *testing.T) {
+	m := NewMemFS()
+	if m.maxSize != defaultMaxSize {
+		t.Errorf("got a quota of %d, want %d", m.maxSize, defaultMaxSize)
+	}
+
+	m.SetQuota(-1, 0)
+	if m.maxSize != -1 {
+		t.Errorf("SetQuota: got a quota of %d, want no limit", m.maxSize)
+	}
+}
+
+func TestMemFSPermissions(t *testing.T) {
+	m := NewMemFS()
+	if err := writeFile(m, "file", "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mkdir("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Chmod("file", 0o200); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(m, "file"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("read a write-only file: got %v", err)
+	}
+
+	if err := m.Chmod("file", 0o400); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(m, "file", "data"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("write a read-only file: got %v", err)
+	}
+
+	if err := m.Chmod("dir", 0o555); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(m, "dir/file", "data"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("create a file in a read-only directory: got %v", err)
+	}
+}
+
+func TestMemFSSymlinks(t *testing.T) {
+	m := NewMemFS()
+	if err := m.Mkdir("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(m, "dir/file", "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"relative": "dir/file",
+		"absolute": "/dir/file",
+		"escape":   "../../dir/file",
+		"loop":     "loop",
+	}
+	for name, target := range links {
+		if err := m.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"relative", "absolute", "escape"} {
+		if b, err := fs.ReadFile(m, name); err != nil || string(b) != "data" {
+			t.Errorf("%s: ReadFile: got %q, %v", name, b, err)
+		}
+	}
+
+	if _, err := fs.ReadFile(m, "loop"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("loop: ReadFile: got %v, want %v", err, syscall.ELOOP)
+	}
+
+	if target, err := m.ReadLink("absolute"); err != nil || target != "/dir/file" {
+		t.Errorf("ReadLink: got %q, %v", target, err)
+	}
+	if info, err := m.Lstat("relative"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat: got %v, %v", info, err)
+	}
+}
+
+func TestMemFSTar(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var seed bytes.Buffer
+	tw := tar.NewWriter(&seed)
+	for _, hdr := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0o750, ModTime: modTime},
+		{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0o640, Size: 4, ModTime: modTime},
+		{Typeflag: tar.TypeReg, Name: "nested/deeper/file", Mode: 0o600, Size: 6, ModTime: modTime},
+		{Typeflag: tar.TypeLink, Name: "hardlink", Linkname: "dir/file", Mode: 0o644, ModTime: modTime},
+		{Typeflag: tar.TypeSymlink, Name: "symlink", Linkname: "dir/file", ModTime: modTime},
+		{Typeflag: tar.TypeFifo, Name: "fifo", Mode: 0o644, ModTime: modTime},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, "data\nfile"[:hdr.Size]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemFS()
+	if err := m.ImportTar(&seed); err != nil {
+		t.Fatalf("ImportTar: %v", err)
+	}
+
+	// Export the file system and import it again, after which it should be
+	// the same.
+	var archive bytes.Buffer
+	if err := m.ExportTar(&archive); err != nil {
+		t.Fatalf("ExportTar: %v", err)
+	}
+
+	imported := NewMemFS()
+	if err := imported.ImportTar(&archive); err != nil {
+		t.Fatalf("ImportTar: %v", err)
+	}
+
+	files := map[string]struct {
+		mode     fs.FileMode
+		contents string
+	}{
+		"dir":                {mode: fs.ModeDir | 0o750},
+		"dir/file":           {mode: 0o640, contents: "data"},
+		"nested":             {mode: fs.ModeDir | 0o755},
+		"nested/deeper":      {mode: fs.ModeDir | 0o755},
+		"nested/deeper/file": {mode: 0o600, contents: "data\nf"},
+		"hardlink":           {mode: 0o644, contents: "data"},
+	}
+
+	for _, fsys := range []*MemFS{m, imported} {
+		for name, want := range files {
+			info, err := fsys.Lstat(name)
+			if err != nil {
+				t.Errorf("%s: Lstat: %v", name, err)
+				continue
+			}
+			if info.Mode() != want.mode {
+				t.Errorf("%s: got mode %v, want %v", name, info.Mode(), want.mode)
+			}
+			if !want.mode.IsDir() {
+				if b, err := fs.ReadFile(fsys, name); err != nil || string(b) != want.contents {
+					t.Errorf("%s: ReadFile: got %q, %v", name, b, err)
+				}
+				if !info.ModTime().Equal(modTime) {
+					t.Errorf("%s: got modification time %v, want %v", name, info.ModTime(), modTime)
+				}
+			}
+		}
+
+		if target, err := fsys.ReadLink("symlink"); err != nil || target != "dir/file" {
+			t.Errorf("symlink: ReadLink: got %q, %v", target, err)
+		}
+		if _, err := fsys.Lstat("fifo"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("fifo: Lstat: got %v, want %v", err, fs.ErrNotExist)
+		}
+	}
+
+	if err := fstest.TestFS(imported, "dir/file", "nested/deeper/file", "hardlink"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemFSImportQuota(t *testing.T) {
+	var seed bytes.Buffer
+	tw := tar.NewWriter(&seed)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemFS()
+	m.SetQuota(3, 0)
+	if err := m.ImportTar(&seed); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("ImportTar: got %v, want %v", err, syscall.ENOSPC)
+	}
+}
+
+// writeFileAt writes b to the file name at offset off.
+func writeFileAt(fsys WritableFS, name string, b []byte, off int64) error {
+	f, err := fsys.OpenFile(name, syscall.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, ok := f.(io.WriterAt)
+	if !ok {
+		return errors.New("file is not writable at an offset")
+	}
+
+	_, err = w.WriteAt(b, off)
+	return err
+}
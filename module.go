@@ -1,12 +1,16 @@
 package wasmexec
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"math"
 	"reflect"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -17,16 +21,52 @@ const nanHead = 0x7FF80000
 // NaN describes a not-a-number value.
 var NaN = math.NaN()
 
+// ErrExited is returned whenever the guest is called after it has exited.
+var ErrExited = errors.New("program has already exited")
+
+// ErrIdle is returned by Run when the guest has not exited, but is idle and
+// waiting for the host to call into it.
+var ErrIdle = errors.New("program is idle and waiting for the host")
+
+// ErrGuestDeadlock is returned whenever the host is waiting on the guest while
+// the guest is unable to make any more progress, because all its goroutines
+// are asleep and there are no timeout events pending.
+type ErrGuestDeadlock struct {
+	Operation string
+}
+
+// Error implements the error interface.
+func (err *ErrGuestDeadlock) Error() string {
+	return fmt.Sprintf("%s: all goroutines in the guest are asleep - deadlock", err.Operation)
+}
+
 // invokeContext keeps track of the response from the guest during an Invoke
 // call.
 type invokeContext struct {
+	id        uint32
+	operation string
 	guestResp []byte
 	guestErr  string
+	err       error
 	success   chan bool
+
+	// signal is passed to the guest and is used to notify it when the call
+	// is abandoned by the host. It mimics JavaScript's AbortSignal.
+	signal *jsObject
 }
 
-func newInvokeContext() *invokeContext {
-	return &invokeContext{success: make(chan bool, 1)}
+func newInvokeContext(id uint32, operation string) *invokeContext {
+	return &invokeContext{
+		id:        id,
+		operation: operation,
+		success:   make(chan bool, 1),
+		signal: &jsObject{
+			properties: jsProperties{
+				"aborted": false,
+				"onabort": nil,
+			},
+		},
+	}
 }
 
 // debugLogger describes an instance that has implemented a debug logger.
@@ -44,11 +84,28 @@ type fdWriter interface {
 	Write(fd int, data []byte) (n int, err error)
 }
 
+// fdReader describes an instance that has implemented os.Read for standard
+// input. Read is allowed to block until data is available.
+type fdReader interface {
+	Read(fd int, data []byte) (n int, err error)
+}
+
+// entropySource describes an instance that has implemented a source of random
+// data for the guest.
+type entropySource interface {
+	Entropy() io.Reader
+}
+
 // exiter describes an Instance that has implemented an Exit method.
 type exiter interface {
 	Exit(code int)
 }
 
+// runner describes an instance that has implemented the run export.
+type runner interface {
+	Run(argc, argv int32) error
+}
+
 // hostCaller describes an instance that has implemented the waPC HostCall method.
 type hostCaller interface {
 	HostCall(string, string, string, []byte) ([]byte, error)
@@ -57,12 +114,28 @@ type hostCaller interface {
 // Module implements the JavaScript imports that a Go program compiled with
 // GOOS=js expects.
 type Module struct {
-	instance      Instance
-	invokeContext *invokeContext
+	instance Instance
+
+	// guest serializes the execution of the guest. Sending on it acquires
+	// the guest and receiving from it releases the guest again.
+	guest chan struct{}
+
+	// invokes holds the Invoke calls that are waiting for a response from
+	// the guest, indexed by their correlation ID.
+	nextInvokeID uint32
+	invokes      map[uint32]*invokeContext
+
+	// abortMu protects aborts, which holds the Invoke calls that were
+	// abandoned by the host and of which the guest has not been notified yet.
+	abortMu sync.Mutex
+	aborts  []*invokeContext
 
 	debugLog debugLogger
 	errorLog errorLogger
 	writer   fdWriter
+	reader   fdReader
+	clock    Clock
+	entropy  io.Reader
 	exit     exiter
 	waPC     hostCaller
 
@@ -70,6 +143,55 @@ type Module struct {
 	ids       map[any]uint32
 	values    map[uint32]any
 	refcounts map[uint32]int32
+
+	fsys   fs.FS
+	nextFD int
+	files  map[int]*openFile
+
+	// readMu serializes the reads from standard input, of which pendingReads
+	// are waiting to hand their result to the guest.
+	readMu       sync.Mutex
+	pendingReads int
+
+	nextTimeoutID int32
+	timeouts      map[int32]*timeout
+
+	// pendingPromises is the number of promises created by NewPromise that
+	// are not settled yet. It is accessed atomically, as promises can be
+	// created without the guest locked.
+	pendingPromises int32
+
+	// constructors holds the built-in constructors of the global object,
+	// indexed by their name.
+	constructors map[string]*jsFunction
+
+	// jobs holds the promise reactions that run once the guest has given
+	// back control, and awaits holds the Await calls that are waiting for a
+	// promise to settle.
+	jobs   []func()
+	awaits map[*awaiter]struct{}
+
+	// virtual is the clock of the guest in virtual time, which advancing
+	// moves forward while running is true or Invoke or Await calls are
+	// waiting.
+	virtual   *virtualClock
+	advancing bool
+	running   bool
+
+	// idle is closed when the guest that Run is driving is idle and has
+	// nothing left that could resume it, other than a call from the host.
+	idle chan struct{}
+
+	// done is closed when the guest has exited or was stopped, after which
+	// exitCode and err are set.
+	done     chan struct{}
+	exitCode int
+	err      error
+
+	// ctx is passed to the bound Go functions that take a context.Context.
+	// It is cancelled when the guest has exited or was stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // New returns a new Module.
@@ -77,19 +199,67 @@ func New(instance Instance) *Module {
 	debugLog, _ := instance.(debugLogger)
 	errorLog, _ := instance.(errorLogger)
 	writer, _ := instance.(fdWriter)
+	reader, _ := instance.(fdReader)
+
+	var clock Clock
+	if c, ok := instance.(clocker); ok {
+		clock = c.Clock()
+	}
+	if clock == nil {
+		clock = newSystemClock()
+	}
+	if l, ok := instance.(locationer); ok {
+		if location := l.Location(); location != nil {
+			clock = &zonedClock{Clock: clock, location: location}
+		}
+	}
+
+	var entropy io.Reader
+	if e, ok := instance.(entropySource); ok {
+		entropy = e.Entropy()
+	}
+	if entropy == nil {
+		entropy = rand.Reader
+	}
 	exit, _ := instance.(exiter)
 	waPC, _ := instance.(hostCaller)
 
+	var fsys fs.FS
+	if fsImpl, ok := instance.(fileSystem); ok {
+		fsys = fsImpl.FS()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	var mod *Module
 	mod = &Module{
 		instance: instance,
+		guest:    make(chan struct{}, 1),
 
 		debugLog: debugLog,
 		errorLog: errorLog,
 		writer:   writer,
+		reader:   reader,
+		clock:    clock,
+		entropy:  entropy,
 		exit:     exit,
 		waPC:     waPC,
 
+		invokes: make(map[uint32]*invokeContext),
+		awaits:  make(map[*awaiter]struct{}),
+
+		fsys:   fsys,
+		nextFD: 3,
+		files:  make(map[int]*openFile),
+
+		nextTimeoutID: 1,
+		timeouts:      make(map[int32]*timeout),
+
+		done: make(chan struct{}),
+
+		ctx:    ctx,
+		cancel: cancel,
+
 		idcounter: 10,
 		refcounts: make(map[uint32]int32),
 		ids: map[any]uint32{
@@ -116,43 +286,12 @@ func New(instance Instance) *Module {
 						},
 					},
 
-					"Date": &jsFunction{
-						fn: func([]any) any {
-							return &jsObject{
-								properties: jsProperties{
-									"getTimezoneOffset": &jsFunction{
-										fn: func(args []any) any {
-											t := time.Now()
-											_, offset := t.Zone()
-											return (offset / 60) * -1
-										},
-									},
-								},
-							}
-						},
-					},
-
 					"Object": &jsFunction{
 						name: "Object",
 						fn: func([]any) any {
-							return &jsObject{properties: make(jsProperties)}
-						},
-					},
-
-					"Uint8Array": &jsFunction{
-						name: "Uint8Array",
-						fn: func(args []any) any {
-							if len(args) == 0 {
-								return []byte{}
-							}
-
-							length, ok := args[0].(float64)
-							if !ok {
-								return []byte{}
-							}
-
-							return &jsUint8Array{
-								data: make([]byte, uint32(length)),
+							return &jsObject{
+								constructor: mod.constructors["Object"],
+								properties:  make(jsProperties),
 							}
 						},
 					},
@@ -166,13 +305,13 @@ func New(instance Instance) *Module {
 										return 0
 									}
 
-									a, ok := args[0].(*jsUint8Array)
+									a, ok := args[0].(typedArray)
 									if !ok {
-										mod.error("crypto.getRandomValues: %T: not type jsUint8Array", args[0])
+										mod.error("crypto.getRandomValues: %T: not a typed array", args[0])
 										return 0
 									}
 
-									n, err := rand.Read(a.data)
+									n, err := io.ReadFull(mod.entropy, a.bytes())
 									if err != nil {
 										mod.error("crypto.getRandomValues: %v", err)
 										return 0
@@ -193,94 +332,56 @@ func New(instance Instance) *Module {
 								"O_TRUNC":  syscall.O_TRUNC,
 								"O_APPEND": syscall.O_APPEND,
 								"O_EXCL":   syscall.O_EXCL,
-							},
-
-							"write": &jsFunction{
-								fn: func(args []any) any {
-									if len(args) != 6 {
-										mod.error("fs.write: %d: invalid number of arguments", len(args))
-										return nil
-									}
-
-									val, ok := args[0].(float64)
-									if !ok {
-										mod.error("fs.write: %T: not type float64", args[0])
-										return nil
-									}
-									fd := int(val)
 
-									buf, ok := args[1].(*jsUint8Array)
-									if !ok {
-										mod.error("fs.write: %T: not type jsUint8Array", args[1])
-										return nil
-									}
-
-									/*
-										offset, ok := args[2].(int)
-										if !ok {
-											mod.error("fs.write: %T: not type int", args[2])
-											return 9
-										}
-
-										val, ok = args[3].(float64)
-										if !ok {
-											mod.error("fs.write: %T: not type float64", args[3])
-											return 9
-										}
-										length := int(val)
-
-										var position int64
-										if args[4] != nil {
-											val, ok = args[4].(float64)
-											if !ok {
-												mod.error("fs.write: %T: not type float64", args[4])
-												return 9
-											}
-
-											position = int64(val)
-										}
-									*/
+								"O_DIRECTORY": oDIRECTORY,
+							},
 
-									callback, ok := args[5].(*jsFunction)
-									if !ok {
-										mod.error("fs.write: %T: not type jsFunction", args[5])
-										return nil
-									}
+							"write": fsFunction(func(args []any) (any, error) { return mod.fsWrite(args) }),
+
+							"close":    fsFunction(func(args []any) (any, error) { return mod.fsClose(args) }),
+							"fstat":    fsFunction(func(args []any) (any, error) { return mod.fsFstat(args) }),
+							"lstat":    fsFunction(func(args []any) (any, error) { return mod.fsLstat(args) }),
+							"open":     fsFunction(func(args []any) (any, error) { return mod.fsOpen(args) }),
+							"read":     fsAsyncFunction(func(args []any, done func(any, error)) { mod.fsRead(args, done) }),
+							"readdir":  fsFunction(func(args []any) (any, error) { return mod.fsReaddir(args) }),
+							"readlink": fsFunction(func(args []any) (any, error) { return mod.fsReadlink(args) }),
+							"stat":     fsFunction(func(args []any) (any, error) { return mod.fsStat(args) }),
+
+							"chmod":     fsFunction(func(args []any) (any, error) { return mod.fsChmod(args) }),
+							"fchmod":    fsFunction(func(args []any) (any, error) { return mod.fsFchmod(args) }),
+							"fsync":     fsFunction(func(args []any) (any, error) { return mod.fsFsync(args) }),
+							"ftruncate": fsFunction(func(args []any) (any, error) { return mod.fsFtruncate(args) }),
+							"mkdir":     fsFunction(func(args []any) (any, error) { return mod.fsMkdir(args) }),
+							"rename":    fsFunction(func(args []any) (any, error) { return mod.fsRename(args) }),
+							"rmdir":     fsFunction(func(args []any) (any, error) { return mod.fsRmdir(args) }),
+							"truncate":  fsFunction(func(args []any) (any, error) { return mod.fsTruncate(args) }),
+							"symlink":   fsFunction(func(args []any) (any, error) { return mod.fsSymlink(args) }),
+							"unlink":    fsFunction(func(args []any) (any, error) { return mod.fsUnlink(args) }),
+							"utimes":    fsFunction(func(args []any) (any, error) { return mod.fsUtimes(args) }),
+
+							"chown":  fsFunction(func(args []any) (any, error) { return mod.fsUnsupported(args) }),
+							"fchown": fsFunction(func(args []any) (any, error) { return mod.fsUnsupported(args) }),
+							"lchown": fsFunction(func(args []any) (any, error) { return mod.fsUnsupported(args) }),
+							"link":   fsFunction(func(args []any) (any, error) { return mod.fsUnsupported(args) }),
+						},
+					},
 
-									n, err := mod.write(fd, buf.data)
+					"path": &jsObject{
+						properties: jsProperties{
+							"resolve": newjsFunction(func(args []any) any {
+								paths := make([]string, 0, len(args))
+								for i := range args {
+									p, err := argString(args, i)
 									if err != nil {
-										callback.fn(errorResponse(eNOSYS))
+										mod.error("path.resolve: %T: not type jsString", args[i])
 										return nil
 									}
 
-									callback.fn([]any{nil, n})
-									return nil
-								},
-							},
+									paths = append(paths, p)
+								}
 
-							"chmod":     errorCallback(eNOSYS),
-							"chown":     errorCallback(eNOSYS),
-							"close":     errorCallback(eNOSYS),
-							"fchmod":    errorCallback(eNOSYS),
-							"fchown":    errorCallback(eNOSYS),
-							"fstat":     errorCallback(eNOSYS),
-							"fsync":     errorCallback(eNOSYS),
-							"ftruncate": errorCallback(eNOSYS),
-							"lchown":    errorCallback(eNOSYS),
-							"link":      errorCallback(eNOSYS),
-							"lstat":     errorCallback(eNOSYS),
-							"mkdir":     errorCallback(eNOSYS),
-							"open":      errorCallback(eNOSYS),
-							"read":      errorCallback(eNOSYS),
-							"readdir":   errorCallback(eNOSYS),
-							"readlink":  errorCallback(eNOSYS),
-							"rename":    errorCallback(eNOSYS),
-							"rmdir":     errorCallback(eNOSYS),
-							"stat":      errorCallback(eNOSYS),
-							"symlink":   errorCallback(eNOSYS),
-							"truncate":  errorCallback(eNOSYS),
-							"unlink":    errorCallback(eNOSYS),
-							"utimes":    errorCallback(eNOSYS),
+								return resolvePath(paths...)
+							}),
 						},
 					},
 
@@ -306,13 +407,20 @@ func New(instance Instance) *Module {
 						properties: jsProperties{
 							"__guest_response": &jsFunction{
 								fn: func(args []any) any {
-									if len(args) != 1 {
+									if len(args) != 2 {
+										return nil
+									}
+
+									// Responses to abandoned calls are dropped.
+									ic, ok := mod.popInvoke(args[0])
+									if !ok {
+										mod.debug("   __guest_response: %v: no such invoke in progress", args[0])
 										return nil
 									}
 
-									if resp, ok := args[0].(*jsUint8Array); ok {
-										mod.invokeContext.guestResp = resp.data
-										mod.invokeContext.success <- true
+									if resp, ok := args[1].(*jsUint8Array); ok {
+										ic.guestResp = resp.data
+										ic.success <- true
 									}
 
 									return nil
@@ -320,13 +428,20 @@ func New(instance Instance) *Module {
 							},
 							"__guest_error": &jsFunction{
 								fn: func(args []any) any {
-									if len(args) != 1 {
+									if len(args) != 2 {
+										return nil
+									}
+
+									// Responses to abandoned calls are dropped.
+									ic, ok := mod.popInvoke(args[0])
+									if !ok {
+										mod.debug("   __guest_error: %v: no such invoke in progress", args[0])
 										return nil
 									}
 
-									if resp, ok := args[0].(*jsUint8Array); ok {
-										mod.invokeContext.guestErr = string(resp.data)
-										mod.invokeContext.success <- false
+									if resp, ok := args[1].(*jsUint8Array); ok {
+										ic.guestErr = string(resp.data)
+										ic.success <- false
 									}
 
 									return nil
@@ -391,25 +506,29 @@ func New(instance Instance) *Module {
 
 							id := args[0]
 
-							return &jsFunction{
-								fn: func(args []any) any {
-									event := &jsObject{
-										properties: jsProperties{
-											"id": id,
-											// "this": mod.values[6].(*jsObject),
-											"this": nil,
-											"args": &jsArray{elements: args},
-										},
-									}
+							method := func(this any, args []any) any {
+								event := &jsObject{
+									properties: jsProperties{
+										"id":   id,
+										"this": this,
+										"args": &jsArray{elements: args},
+									},
+								}
 
-									mod.values[6].(*jsObject).properties["_pendingEvent"] = event
-									if err := mod.instance.Resume(); err != nil {
-										mod.error("_makeFuncWrapper: Resume: %v", err)
-										return nil
-									}
+								mod.values[6].(*jsObject).properties["_pendingEvent"] = event
+								if err := mod.resume(); err != nil {
+									mod.error("_makeFuncWrapper: Resume: %v", err)
+									return nil
+								}
 
-									return event.properties["result"]
+								return event.properties["result"]
+							}
+
+							return &jsFunction{
+								fn: func(args []any) any {
+									return method(nil, args)
 								},
+								method: method,
 							}
 						},
 					},
@@ -418,43 +537,180 @@ func New(instance Instance) *Module {
 		},
 	}
 
+	mod.constructors = mod.errorConstructors()
+	mod.constructors["Promise"] = mod.promiseConstructor()
+	mod.constructors["Date"] = mod.dateConstructor()
+	for name, fn := range mod.typedArrayConstructors() {
+		mod.constructors[name] = fn
+	}
+	for name, fn := range mod.constructors {
+		mod.global().properties[name] = fn
+	}
+
+	for _, name := range []string{"Array", "Object"} {
+		mod.constructors[name] = mod.global().properties[name].(*jsFunction)
+	}
+	mod.constructors["Object"].properties = mod.objectStatics()
+	for name, v := range mod.builtins() {
+		mod.global().properties[name] = v
+	}
+
 	return mod
 }
 
-// Call a function created by js.FuncOf().
-func (mod *Module) Call(name string, args ...any) (any, error) {
-	obj, ok := mod.values[5].(*jsObject)
-	if !ok {
-		return nil, errors.New("global not an object")
+// EnableVirtualTime switches the guest to virtual time, which needs to happen
+// before the guest runs. In virtual time, the clock of the guest stands still
+// while the guest executes and timeout events do not wait in real time.
+// Instead, whenever the guest is idle, its clock jumps forward to the next
+// timeout event, which then fires immediately. A guest that sleeps for an hour
+// finishes in an instant, while still observing consistent time.
+//
+// The virtual clock starts at the current time of the clock of the instance.
+// It only moves forward while Run or an Invoke call waits for the guest, and
+// not while the guest waits for data from stdin.
+func (mod *Module) EnableVirtualTime() {
+	if mod.lock(context.Background()) != nil {
+		return
 	}
+	defer mod.unlock()
 
-	prop, ok := obj.properties[name]
-	if !ok {
-		return nil, fmt.Errorf("%s: not found", name)
+	if mod.virtual == nil {
+		mod.virtual = newVirtualClock(mod.clock)
+		mod.clock = mod.virtual
 	}
+}
 
-	fn, ok := prop.(*jsFunction)
+// Run calls the run export of the instance with the specified arguments and
+// environment variables, after which it drives the guest until it exits or ctx
+// is cancelled. In the meantime, the guest is resumed whenever a timeout event
+// it scheduled fires or when the host calls into it.
+//
+// Run returns the exit code of the guest and any error that caused the guest
+// to stop executing. If ctx is cancelled, the guest is stopped and ctx.Err()
+// is returned.
+//
+// A guest that waits for calls from the host, like a waPC guest that blocks
+// in main after registering its functions, never exits. Run returns ErrIdle
+// for such a guest once it is idle and has nothing left that could resume it,
+// such as a timeout event, a read from stdin or a pending promise. After that,
+// the host can call into the guest as usual.
+func (mod *Module) Run(ctx context.Context, args, envs []string) (int, error) {
+	r, ok := mod.instance.(runner)
 	if !ok {
-		return nil, fmt.Errorf("%s: not a function", name)
+		return 0, errors.New("instance does not implement Run")
+	}
+
+	argc, argv, err := SetArgs(mod.instance, args, envs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = mod.lock(ctx); err != nil {
+		return 0, err
+	}
+
+	idle := make(chan struct{})
+	mod.idle = idle
+	mod.running = true
+	if err = r.Run(argc, argv); err != nil {
+		mod.stop(err)
+	}
+	mod.unlock()
+
+	select {
+	case <-mod.done:
+		return mod.exitCode, mod.err
+	case <-idle:
+		return 0, ErrIdle
+	case <-ctx.Done():
+		if mod.lock(context.Background()) == nil {
+			mod.stop(ctx.Err())
+			mod.unlock()
+		}
+
+		// The guest might have exited while waiting for the lock.
+		return mod.exitCode, mod.err
 	}
+}
+
+// Call a function created by js.FuncOf().
+func (mod *Module) Call(name string, args ...any) (any, error) {
+	return mod.CallContext(context.Background(), name, args...)
+}
+
+// CallContext calls a function created by js.FuncOf(). If ctx is done before
+// the call completes, ctx.Err() is returned. A call that was already running
+// in the guest at that point runs to completion, but its result is discarded.
+func (mod *Module) CallContext(ctx context.Context, name string, args ...any) (any, error) {
+	return mod.enter(ctx, func() (any, error) {
+		return mod.call(name, nil, args...)
+	})
+}
 
-	return fn.fn(args), nil
+// Apply calls a function created by js.FuncOf() with this as the receiver,
+// which the guest gets as the this argument of the function. The receiver is
+// converted like the value passed to SetGlobal.
+func (mod *Module) Apply(name string, this any, args ...any) (any, error) {
+	return mod.ApplyContext(context.Background(), name, this, args...)
+}
+
+// ApplyContext is like Apply, but returns ctx.Err() if ctx is done before the
+// call completes, like CallContext.
+func (mod *Module) ApplyContext(ctx context.Context, name string, this any, args ...any) (any, error) {
+	v, err := mod.toJS(this)
+	if err != nil {
+		return nil, err
+	}
+
+	return mod.enter(ctx, func() (any, error) {
+		return mod.call(name, v, args...)
+	})
 }
 
 // Invoke calls operation with the specified payload and returns a []byte payload.
+// It is safe to call Invoke from multiple goroutines simultaneously.
 func (mod *Module) Invoke(operation string, payload []byte) ([]byte, error) {
-	mod.invokeContext = newInvokeContext()
+	return mod.InvokeContext(context.Background(), operation, payload)
+}
 
-	_, err := mod.Call("__guest_call", operation, payload)
+// InvokeContext calls operation with the specified payload and returns a
+// []byte payload. If ctx is done before the guest responds, ctx.Err() is
+// returned and the call is abandoned: the guest is notified through the
+// context passed to its handler and any response it sends afterwards is
+// dropped.
+//
+// If the guest becomes idle without responding and there is nothing left
+// that could wake it up, an *ErrGuestDeadlock is returned.
+func (mod *Module) InvokeContext(ctx context.Context, operation string, payload []byte) ([]byte, error) {
+	ic := newInvokeContext(atomic.AddUint32(&mod.nextInvokeID, 1), operation)
+
+	_, err := mod.enter(ctx, func() (any, error) {
+		mod.invokes[ic.id] = ic
+		return mod.call("__guest_call", nil, ic.id, operation, payload, ic.signal)
+	})
 	if err != nil {
+		mod.abortInvoke(ic)
 		return nil, err
 	}
 
-	if ok := <-mod.invokeContext.success; !ok {
-		return nil, errors.New(mod.invokeContext.guestErr)
-	}
+	select {
+	case ok := <-ic.success:
+		switch {
+		case ic.err != nil:
+			return nil, ic.err
+		case !ok:
+			return nil, errors.New(ic.guestErr)
+		}
+
+		return ic.guestResp, nil
 
-	return mod.invokeContext.guestResp, nil
+	case <-ctx.Done():
+		mod.abortInvoke(ic)
+		return nil, ctx.Err()
+
+	case <-mod.done:
+		return nil, mod.exitErr()
+	}
 }
 
 // ****************************************************************************
@@ -473,6 +729,250 @@ func (mod *Module) error(format string, params ...any) {
 	}
 }
 
+// lock acquires exclusive access to the guest. It returns an error if ctx is
+// done or the guest has exited before the guest could be acquired.
+//
+// Any abandoned Invoke calls are aborted before lock returns.
+func (mod *Module) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case mod.guest <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-mod.done:
+		return mod.exitErr()
+	}
+
+	mod.flushAborts()
+	return nil
+}
+
+// unlock releases the guest acquired with lock, after running any queued
+// promise reactions. At this point the guest is idle, so any Invoke or Await
+// call that is still waiting for the guest is failed if the guest is unable
+// to make progress.
+func (mod *Module) unlock() {
+	mod.runJobs()
+
+	if (len(mod.invokes) > 0 || len(mod.awaits) > 0) && !mod.exited() && mod.deadlocked() {
+		for id, ic := range mod.invokes {
+			mod.debug("   unlock: %s: deadlock", ic.operation)
+
+			ic.err = &ErrGuestDeadlock{Operation: ic.operation}
+			ic.success <- false
+			delete(mod.invokes, id)
+		}
+
+		for a := range mod.awaits {
+			mod.debug("   unlock: Await: deadlock")
+
+			close(a.deadlock)
+			delete(mod.awaits, a)
+		}
+	}
+
+	// Run stops waiting for a guest that only waits for the host.
+	if mod.running && !mod.exited() && mod.deadlocked() {
+		mod.running = false
+		close(mod.idle)
+	}
+
+	mod.advanceTime()
+	<-mod.guest
+}
+
+// deadlocked returns true if there is nothing pending that could resume the
+// guest.
+//
+// This method must be called with the guest locked.
+func (mod *Module) deadlocked() bool {
+	return len(mod.timeouts) == 0 && mod.pendingReads == 0 && atomic.LoadInt32(&mod.pendingPromises) == 0
+}
+
+// enter calls fn with exclusive access to the guest. If ctx is done before fn
+// returns, enter returns ctx.Err() while fn keeps running in the background.
+func (mod *Module) enter(ctx context.Context, fn func() (any, error)) (any, error) {
+	if err := mod.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		value any
+		err   error
+	}
+
+	c := make(chan result, 1)
+	go func() {
+		defer mod.unlock()
+
+		value, err := fn()
+		c <- result{value: value, err: err}
+	}()
+
+	select {
+	case res := <-c:
+		return res.value, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// call calls the global function with the specified name, with this as its
+// receiver.
+//
+// This method must be called with the guest locked.
+func (mod *Module) call(name string, this any, args ...any) (any, error) {
+	if mod.exited() {
+		return nil, mod.exitErr()
+	}
+
+	obj, ok := mod.values[5].(*jsObject)
+	if !ok {
+		return nil, errors.New("global not an object")
+	}
+
+	prop, ok := obj.properties[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", name)
+	}
+
+	fn, ok := prop.(*jsFunction)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a function", name)
+	}
+
+	return mod.callFunction(name, fn, this, args)
+}
+
+// callFunction calls fn with this as its receiver. A value thrown by fn is
+// returned as an *Error, while name identifies the call in the error that is
+// returned if the guest does not handle it.
+//
+// This method must be called with the guest locked.
+func (mod *Module) callFunction(name string, fn *jsFunction, this any, args []any) (any, error) {
+	if mod.exited() {
+		return nil, mod.exitErr()
+	}
+
+	result := fn.call(this, args)
+	if thrown, ok := result.(*jsThrow); ok {
+		return nil, mod.exception(thrown.value)
+	}
+
+	// If the guest gave back control without picking up the event created by
+	// the js.FuncOf() wrapper, it is not going to handle this call.
+	jsGo := mod.values[6].(*jsObject)
+	if jsGo.properties["_pendingEvent"] != nil && !mod.exited() {
+		jsGo.properties["_pendingEvent"] = nil
+		return nil, &ErrGuestDeadlock{Operation: name}
+	}
+
+	return result, nil
+}
+
+// popInvoke removes the Invoke call with the correlation ID specified by v
+// and returns it.
+//
+// This method must be called with the guest locked.
+func (mod *Module) popInvoke(v any) (*invokeContext, bool) {
+	id, ok := v.(float64)
+	if !ok {
+		return nil, false
+	}
+
+	ic, ok := mod.invokes[uint32(id)]
+	if ok {
+		delete(mod.invokes, ic.id)
+	}
+
+	return ic, ok
+}
+
+// abortInvoke marks an Invoke call as abandoned. The guest is notified the
+// next time it is entered.
+func (mod *Module) abortInvoke(ic *invokeContext) {
+	mod.abortMu.Lock()
+	mod.aborts = append(mod.aborts, ic)
+	mod.abortMu.Unlock()
+}
+
+// flushAborts notifies the guest of all abandoned Invoke calls.
+//
+// This method must be called with the guest locked.
+func (mod *Module) flushAborts() {
+	mod.abortMu.Lock()
+	aborts := mod.aborts
+	mod.aborts = nil
+	mod.abortMu.Unlock()
+
+	for _, ic := range aborts {
+		delete(mod.invokes, ic.id)
+
+		if mod.exited() {
+			continue
+		}
+
+		ic.signal.properties["aborted"] = true
+		if fn, ok := ic.signal.properties["onabort"].(*jsFunction); ok {
+			fn.fn(nil)
+		}
+	}
+}
+
+// resume resumes the execution of the guest. Any error returned by the
+// instance stops the guest.
+//
+// This method must be called with the guest locked.
+func (mod *Module) resume() error {
+	if mod.exited() {
+		return mod.exitErr()
+	}
+
+	if err := mod.instance.Resume(); err != nil {
+		mod.stop(err)
+		return err
+	}
+
+	return nil
+}
+
+// exited returns true if the guest has exited or was stopped.
+func (mod *Module) exited() bool {
+	select {
+	case <-mod.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// exitErr returns the error that describes why the guest is no longer running.
+func (mod *Module) exitErr() error {
+	if mod.err != nil {
+		return mod.err
+	}
+
+	return ErrExited
+}
+
+// stop marks the guest as stopped with the specified error and clears all
+// pending timeout events. Calling stop on an exited guest has no effect.
+//
+// This method must be called with the guest locked.
+func (mod *Module) stop(err error) {
+	if mod.exited() {
+		return
+	}
+
+	mod.clearTimeouts()
+	mod.err = err
+	mod.cancel()
+	close(mod.done)
+}
+
 func (mod *Module) write(fd int, data []byte) (int, error) {
 	if mod.writer == nil {
 		return 0, errors.New("no writer available")
@@ -516,29 +1016,8 @@ func (mod *Module) storeValue(addr uint32, v any) error {
 	mod.debug("   storeValue(addr=%v type=%T v=%v nil=%v)", addr, v, v, (v == nil))
 
 	// Convert any integer to a float64, which is akin to a JSON number.
-	switch num := v.(type) {
-	case int:
-		v = float64(num)
-	case uint:
-		v = float64(num)
-	case int8:
-		v = float64(num)
-	case uint8:
-		v = float64(num)
-	case int16:
-		v = float64(num)
-	case uint16:
-		v = float64(num)
-	case int32:
-		v = float64(num)
-	case uint32:
-		v = float64(num)
-	case int64:
-		v = float64(num)
-	case uint64:
-		v = float64(num)
-	case float32:
-		v = float64(num)
+	if num, ok := toFloat64(v); ok {
+		v = num
 	}
 
 	// setNaN sets a NaN-value on the specified address.
@@ -572,6 +1051,19 @@ func (mod *Module) storeValue(addr uint32, v any) error {
 		return setNaN(4)
 	}
 
+	// The global object and the Go object have fixed IDs, which they keep
+	// for as long as the guest runs.
+	if obj, ok := v.(*jsObject); ok {
+		for _, id := range []uint32{5, 6} {
+			if mod.values[id] == obj {
+				if err := mod.instance.SetUInt32(addr+4, nanHead|1); err != nil {
+					return err
+				}
+				return mod.instance.SetUInt32(addr, id)
+			}
+		}
+	}
+
 	// Convert slices to the jsArray type.
 	if a, ok := v.([]any); ok {
 		v = &jsArray{elements: a}
@@ -587,8 +1079,16 @@ func (mod *Module) storeValue(addr uint32, v any) error {
 		v = &jsUint8Array{data: b}
 	}
 
-	// Create a unique signature of the value.
-	signature := fmt.Sprintf("%d", reflect.ValueOf(v).Pointer())
+	// Create a unique signature of the value. A value without an identity,
+	// like a HostObject that is not implemented by a pointer type, gets a new
+	// signature every time.
+	var signature string
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Func, reflect.Chan, reflect.Slice, reflect.UnsafePointer:
+		signature = fmt.Sprintf("%d", rv.Pointer())
+	default:
+		signature = fmt.Sprintf("%T:%d", v, mod.idcounter)
+	}
 	mod.debug("   storeValue(type=%T signature=%v)", v, signature)
 
 	// Use the signature to check if this value has already been stored. If not,
@@ -612,12 +1112,18 @@ func (mod *Module) storeValue(addr uint32, v any) error {
 			typeFlag = 1
 		}
 
+	case *jsTypedArray, *jsArrayBuffer, *jsDataView, *jsDate:
+		typeFlag = 1
+
 	case *jsString:
 		typeFlag = 2
 
 	case *jsFunction:
 		typeFlag = 4
 
+	case HostObject, *Promise:
+		typeFlag = 1
+
 	default:
 		panic(fmt.Sprintf("%T: unknown value type", t))
 	}
@@ -692,17 +1198,28 @@ func (mod *Module) reflectApply(v any, name string, args []any) (any, error) {
 		return nil, err
 	}
 
-	return mod.reflectConstruct(obj, args)
+	// The object becomes the receiver of the method, like in JavaScript.
+	return mod.reflectCallFunction(obj, v, args)
 }
 
 func (mod *Module) reflectConstruct(v any, args []any) (any, error) {
 	mod.debug("   reflectConstruct(v=%v args=%v)", v, args)
 
+	return mod.reflectCallFunction(v, nil, args)
+}
+
+// reflectCallFunction calls the function v with this as its receiver.
+func (mod *Module) reflectCallFunction(v, this any, args []any) (any, error) {
 	if fn, ok := v.(*jsFunction); ok {
-		return fn.fn(args), nil
+		result := fn.call(this, args)
+		if t, ok := result.(*jsThrow); ok {
+			return nil, t
+		}
+
+		return result, nil
 	}
 
-	return nil, fmt.Errorf("%T: not a function", v)
+	return nil, typeError("%T: not a function", v)
 }
 
 func (mod *Module) reflectGet(v, key any) (any, error) {
@@ -712,12 +1229,35 @@ func (mod *Module) reflectGet(v, key any) (any, error) {
 		v = mod.values[5]
 	}
 
+	if obj, ok := v.(HostObject); ok {
+		name, ok := propertyKey(key)
+		if !ok {
+			return nil, errors.New("key not a string or int64")
+		}
+
+		return mod.hostObjectGet(obj, name)
+	}
+
 	if name, ok := key.(string); ok {
 		switch vv := v.(type) {
 		case *jsObject:
-			return vv.properties[name], nil
+			return objectGet(vv.properties, name), nil
 		case jsProperties:
-			return vv[name], nil
+			return objectGet(vv, name), nil
+		case *jsArray:
+			return mod.arrayGet(vv, name), nil
+		case *jsFunction:
+			return vv.properties[name], nil
+		case *Promise:
+			return vv.get(name), nil
+		case typedArray:
+			return mod.typedArrayGet(vv, name), nil
+		case *jsArrayBuffer:
+			return vv.get(name), nil
+		case *jsDataView:
+			return vv.get(mod, name), nil
+		case *jsDate:
+			return vv.get(mod, name), nil
 		}
 	}
 
@@ -726,6 +1266,13 @@ func (mod *Module) reflectGet(v, key any) (any, error) {
 		return nil, errors.New("key not an int64")
 	}
 
+	if ta, ok := v.(typedArray); ok {
+		if f, ok := typedArrayIndex(ta, int(index)); ok {
+			return f, nil
+		}
+		return nil, nil
+	}
+
 	a, ok := v.(*jsArray)
 	switch {
 	case !ok:
@@ -744,8 +1291,55 @@ func (mod *Module) reflectSet(v, key, value any) error {
 		v = mod.values[5]
 	}
 
+	if obj, ok := v.(HostObject); ok {
+		name, ok := propertyKey(key)
+		if !ok {
+			return errors.New("key not a string or int64")
+		}
+
+		gv, err := mod.goValue(value, make(visiting))
+		if err != nil {
+			return err
+		}
+
+		return obj.Set(name, gv)
+	}
+
 	if name, ok := key.(string); ok {
-		v.(*jsObject).properties[name] = value
+		switch vv := v.(type) {
+		case *jsObject:
+			vv.properties[name] = value
+		case jsProperties:
+			vv[name] = value
+		case *jsFunction:
+			if vv.properties == nil {
+				vv.properties = make(jsProperties)
+			}
+			vv.properties[name] = value
+
+		case *jsArray:
+			if name == "length" {
+				length, ok := arrayLength(value)
+				if !ok {
+					return &Error{Name: "RangeError", Message: "Invalid array length"}
+				}
+				return vv.setLength(length)
+			}
+
+			if i, ok := arrayIndex(name); ok {
+				return mod.reflectSet(vv, int64(i), value)
+			}
+
+			// Any other name is a property of the array, like in JavaScript.
+			if vv.properties == nil {
+				vv.properties = make(jsProperties)
+			}
+			vv.properties[name] = value
+
+		default:
+			return errors.New("value not an object")
+		}
+
 		return nil
 	}
 
@@ -754,14 +1348,26 @@ func (mod *Module) reflectSet(v, key, value any) error {
 		return errors.New("key not an int64")
 	}
 
+	if ta, ok := v.(typedArray); ok {
+		typedArraySetIndex(ta, int(index), value)
+		return nil
+	}
+
 	a, ok := v.(*jsArray)
 	switch {
 	case !ok:
 		return errors.New("value not a slice")
-	case index < 0 || index >= int64(len(a.elements)):
+	case index < 0 || index > math.MaxInt32:
 		return errors.New("index out of range")
 	}
 
+	// Setting an index past the end grows the array, like in JavaScript.
+	if index >= int64(len(a.elements)) {
+		if err := a.setLength(int(index) + 1); err != nil {
+			return err
+		}
+	}
+
 	a.elements[index] = value
 	return nil
 }
@@ -773,8 +1379,39 @@ func (mod *Module) reflectDeleteProperty(v, key any) error {
 		v = mod.values[5]
 	}
 
+	if obj, ok := v.(HostObject); ok {
+		name, ok := propertyKey(key)
+		if !ok {
+			return errors.New("key not a string or int64")
+		}
+
+		return obj.Delete(name)
+	}
+
 	if name, ok := key.(string); ok {
-		delete(v.(*jsObject).properties, name)
+		switch vv := v.(type) {
+		case *jsObject:
+			delete(vv.properties, name)
+		case jsProperties:
+			delete(vv, name)
+		case *jsFunction:
+			delete(vv.properties, name)
+
+		case *jsArray:
+			// Deleting an element leaves a hole, rather than shifting the
+			// elements after it.
+			if i, ok := arrayIndex(name); ok {
+				if int64(i) < int64(len(vv.elements)) {
+					vv.elements[i] = nil
+				}
+				return nil
+			}
+			delete(vv.properties, name)
+
+		default:
+			return errors.New("value not an object")
+		}
+
 		return nil
 	}
 
@@ -809,7 +1446,13 @@ func (mod *Module) wrap(name string, fn func() error) error {
 	}
 
 	if err := fn(); err != nil {
-		if name != "" {
+		// An exception thrown by a function is meant for the guest.
+		var t *jsThrow
+		var e *Error
+		switch {
+		case errors.As(err, &t), errors.As(err, &e):
+			mod.debug("   %s: throw: %v", name, err)
+		case name != "":
 			mod.error("%s: %v", name, err)
 		}
 		return err
@@ -827,16 +1470,21 @@ func (mod *Module) wrap(name string, fn func() error) error {
 // This method is called from the runtime package.
 func (mod *Module) WasmExit(sp uint32) {
 	_ = mod.wrap("runtime.wasmExit", func() error {
-		if mod.exit == nil {
-			return nil
-		}
-
 		v, err := mod.instance.GetUInt32(sp + 8)
 		if err != nil {
 			return err
 		}
 
-		mod.exit.Exit(int(v))
+		// The program is gone, so there is nothing left to resume.
+		if !mod.exited() {
+			mod.exitCode = int(int32(v))
+			mod.stop(nil)
+		}
+
+		if mod.exit != nil {
+			mod.exit.Exit(int(v))
+		}
+
 		return nil
 	})
 }
@@ -884,7 +1532,7 @@ func (mod *Module) ResetMemoryDataView(sp uint32) {
 // This method is called from the runtime package.
 func (mod *Module) Nanotime1(sp uint32) {
 	_ = mod.wrap("runtime.nanotime1", func() error {
-		return mod.instance.SetInt64(sp+8, time.Now().UnixNano())
+		return mod.instance.SetInt64(sp+8, mod.clock.Nanotime())
 	})
 }
 
@@ -893,13 +1541,13 @@ func (mod *Module) Nanotime1(sp uint32) {
 // This method is called from the runtime package.
 func (mod *Module) Walltime(sp uint32) {
 	_ = mod.wrap("runtime.walltime", func() error {
-		msec := time.Now().UnixNano() / int64(time.Millisecond)
+		t := mod.clock.Walltime()
 
-		if err := mod.instance.SetInt64(sp+8, msec/1000); err != nil {
+		if err := mod.instance.SetInt64(sp+8, t.Unix()); err != nil {
 			return err
 		}
 
-		return mod.instance.SetUInt32(sp+16, uint32(msec%1000)*1000000)
+		return mod.instance.SetUInt32(sp+16, uint32(t.Nanosecond()))
 	})
 }
 
@@ -908,14 +1556,31 @@ func (mod *Module) Walltime(sp uint32) {
 //
 // This method is called from the runtime package.
 func (mod *Module) ScheduleTimeoutEvent(sp uint32) {
-	_ = mod.wrap("runtime.scheduleTimeoutEvent", nil)
+	_ = mod.wrap("runtime.scheduleTimeoutEvent", func() error {
+		delay, err := mod.instance.GetInt64(sp + 8)
+		if err != nil {
+			return err
+		}
+
+		id := mod.scheduleTimeout(time.Duration(delay) * time.Millisecond)
+
+		return mod.instance.SetUInt32(sp+16, uint32(id))
+	})
 }
 
 // ClearTimeoutEvent clears a timeout event scheduled by ScheduleTimeoutEvent.
 //
 // This method is called from the runtime package.
 func (mod *Module) ClearTimeoutEvent(sp uint32) {
-	_ = mod.wrap("runtime.clearTimeoutEvent", nil)
+	_ = mod.wrap("runtime.clearTimeoutEvent", func() error {
+		id, err := mod.instance.GetUInt32(sp + 8)
+		if err != nil {
+			return err
+		}
+
+		mod.clearTimeout(int32(id))
+		return nil
+	})
 }
 
 // GetRandomData returns random data.
@@ -928,7 +1593,7 @@ func (mod *Module) GetRandomData(sp uint32) {
 			return err
 		}
 
-		_, err = rand.Read(data)
+		_, err = io.ReadFull(mod.entropy, data)
 		return err
 	})
 }
@@ -945,6 +1610,11 @@ func (mod *Module) FinalizeRef(sp uint32) {
 
 		mod.debug("   id=%v (%T)", id, id)
 
+		// The global object and the Go object are never released.
+		if id == 5 || id == 6 {
+			return nil
+		}
+
 		// Make sure the ID has a reference count.
 		ref, ok := mod.refcounts[id]
 		if !ok {
@@ -1132,14 +1802,8 @@ func (mod *Module) ValueSetIndex(sp uint32) {
 //
 // This method is called from syscall/js.Value.Call().
 func (mod *Module) ValueCall(sp uint32) {
-	var resultSP uint32
+	var result any
 	err := mod.wrap("syscall/js.valueCall", func() error {
-		var err error
-		resultSP, err = mod.instance.GetSP()
-		if err != nil {
-			return err
-		}
-
 		// Fetch the object.
 		v, err := mod.loadValue(sp + 8)
 		if err != nil {
@@ -1159,41 +1823,21 @@ func (mod *Module) ValueCall(sp uint32) {
 		}
 
 		// Call the method on the object with the arguments.
-		result, err := mod.reflectApply(v, name, args)
-		if err != nil {
-			return err
-		}
-
-		// Store the results of the call.
-		if err = mod.storeValue(resultSP+56, result); err != nil {
-			return err
-		}
-
-		return mod.instance.SetUInt8(resultSP+64, 1)
+		result, err = mod.reflectApply(v, name, args)
+		return err
 	})
-	if err == nil || resultSP == 0 {
-		return
-	}
 
-	if err = mod.storeValue(resultSP+56, err.Error()); err != nil {
-		return
+	if err = mod.storeResult(56, result, err); err != nil {
+		mod.error("syscall/js.valueCall: %v", err)
 	}
-
-	_ = mod.instance.SetUInt8(resultSP+54, 0)
 }
 
 // ValueInvoke calls the value v with the specified arguments.
 //
 // This method is called from syscall/js.Value.Invoke().
 func (mod *Module) ValueInvoke(sp uint32) {
-	var resultSP uint32
+	var result any
 	err := mod.wrap("syscall/js.valueInvoke", func() error {
-		var err error
-		resultSP, err = mod.instance.GetSP()
-		if err != nil {
-			return err
-		}
-
 		// Fetch the function.
 		v, err := mod.loadValue(sp + 8)
 		if err != nil {
@@ -1207,27 +1851,13 @@ func (mod *Module) ValueInvoke(sp uint32) {
 		}
 
 		// Call v with the specified arguments.
-		result, err := mod.reflectConstruct(v, args)
-		if err != nil {
-			return err
-		}
-
-		// Store the results of the call.
-		if err = mod.storeValue(resultSP+40, result); err != nil {
-			return err
-		}
-
-		return mod.instance.SetUInt8(resultSP+48, 1)
+		result, err = mod.reflectConstruct(v, args)
+		return err
 	})
-	if err == nil || resultSP == 0 {
-		return
-	}
 
-	if err = mod.storeValue(resultSP+40, err); err != nil {
-		return
+	if err = mod.storeResult(40, result, err); err != nil {
+		mod.error("syscall/js.valueInvoke: %v", err)
 	}
-
-	_ = mod.instance.SetUInt8(resultSP+48, 0)
 }
 
 // ValueNew calls a constructor function with the given arguments. This is akin
@@ -1235,14 +1865,8 @@ func (mod *Module) ValueInvoke(sp uint32) {
 //
 // This method is called from syscall/js.Value.New().
 func (mod *Module) ValueNew(sp uint32) {
-	var resultSP uint32
+	var result any
 	err := mod.wrap("syscall/js.valueNew", func() error {
-		var err error
-		resultSP, err = mod.instance.GetSP()
-		if err != nil {
-			return err
-		}
-
 		// Fetch the constructor function.
 		v, err := mod.loadValue(sp + 8)
 		if err != nil {
@@ -1260,27 +1884,37 @@ func (mod *Module) ValueNew(sp uint32) {
 		mod.debug("   args: %T: %v", args, args)
 
 		// Call the constructor function with the arguments.
-		result, err := mod.reflectConstruct(v, args)
-		if err != nil {
-			return err
-		}
+		result, err = mod.reflectConstruct(v, args)
+		return err
+	})
 
-		// Store the results of the call.
-		if err = mod.storeValue(resultSP+40, result); err != nil {
-			return err
-		}
+	if err = mod.storeResult(40, result, err); err != nil {
+		mod.error("syscall/js.valueNew: %v", err)
+	}
+}
 
-		return mod.instance.SetUInt8(resultSP+48, 1)
-	})
-	if err == nil || resultSP == 0 {
-		return
+// storeResult stores the result of a call at offset off of the stack of the
+// guest, followed by whether the call succeeded. If callErr is not nil, the
+// value thrown for it is stored as the result instead.
+//
+// The stack pointer is fetched after the call, like wasm_exec.js does, as the
+// call might have resumed the guest, which can grow and move its stack.
+func (mod *Module) storeResult(off uint32, result any, callErr error) error {
+	sp, err := mod.instance.GetSP()
+	if err != nil {
+		return err
 	}
 
-	if err = mod.storeValue(resultSP+40, err); err != nil {
-		return
+	ok := uint8(1)
+	if callErr != nil {
+		result, ok = mod.thrownValue(callErr), 0
 	}
 
-	_ = mod.instance.SetUInt8(resultSP+48, 1)
+	if err = mod.storeValue(sp+off, result); err != nil {
+		return err
+	}
+
+	return mod.instance.SetUInt8(sp+off+8, ok)
 }
 
 // ValueLength returns the JavaScript property of "length" of v.
@@ -1296,8 +1930,8 @@ func (mod *Module) ValueLength(sp uint32) {
 		switch val := v.(type) {
 		case *jsArray:
 			return mod.instance.SetInt64(sp+16, int64(len(val.elements)))
-		case *jsUint8Array:
-			return mod.instance.SetInt64(sp+16, int64(len(val.data)))
+		case typedArray:
+			return mod.instance.SetInt64(sp+16, int64(typedArrayLength(val)))
 		case *jsString:
 			return mod.instance.SetInt64(sp+16, int64(len(val.data)))
 		default:
@@ -1319,19 +1953,10 @@ func (mod *Module) ValuePrepareString(sp uint32) {
 			return err
 		}
 
-		var s *jsString
-		switch vv := v.(type) {
-		// Boolean.
-		case bool:
-			s = &jsString{data: fmt.Sprintf("%t", vv)}
-		// Number.
-		case float64:
-			s = &jsString{data: strconv.FormatFloat(vv, 'f', -1, 64)}
-		// jsString.
-		case *jsString:
-			s = vv
-		default:
-			return fmt.Errorf("%T: unable to convert type to string", v)
+		// Convert any other value like String(v) does in JavaScript.
+		s, ok := v.(*jsString)
+		if !ok {
+			s = &jsString{data: toString(v)}
 		}
 
 		if err = mod.storeValue(sp+16, s); err != nil {
@@ -1384,28 +2009,12 @@ func (mod *Module) ValueInstanceOf(sp uint32) {
 			return err
 		}
 
-		lookup, ok := t.(interface{ Name() string })
-		if !ok {
+		fn, ok := t.(*jsFunction)
+		if !ok || !mod.instanceOf(v, fn) {
 			return mod.instance.SetUInt8(sp+24, 0)
 		}
 
-		name := lookup.Name()
-		switch v.(type) {
-		case *jsArray:
-			if name == "Array" {
-				return mod.instance.SetUInt8(sp+24, 1)
-			}
-		case *jsObject:
-			if name == "Object" {
-				return mod.instance.SetUInt8(sp+24, 1)
-			}
-		case *jsUint8Array:
-			if name == "Uint8Array" {
-				return mod.instance.SetUInt8(sp+24, 1)
-			}
-		}
-
-		return mod.instance.SetUInt8(sp+24, 0)
+		return mod.instance.SetUInt8(sp+24, 1)
 	})
 }
 
@@ -1422,16 +2031,12 @@ func (mod *Module) CopyBytesToGo(sp uint32) {
 			return err
 		}
 
-		src, ok := v.(*jsUint8Array)
+		src, ok := byteArray(v)
 		if !ok {
-			return fmt.Errorf("src: %T not type jsUint8Array", v)
-		}
-
-		if len(dst) == 0 || len(src.data) == 0 {
 			return mod.instance.SetUInt8(sp+48, 0)
 		}
 
-		n := copy(dst, src.data)
+		n := copy(dst, src)
 		if err = mod.instance.SetInt64(sp+40, int64(n)); err != nil {
 			return err
 		}
@@ -1448,10 +2053,9 @@ func (mod *Module) CopyBytesToJS(sp uint32) {
 			return err
 		}
 
-		dst, ok := v.(*jsUint8Array)
+		dst, ok := byteArray(v)
 		if !ok {
-			_ = mod.instance.SetUInt8(sp+48, 1)
-			return fmt.Errorf("dst: %T not type jsUint8Array", v)
+			return mod.instance.SetUInt8(sp+48, 0)
 		}
 
 		src, err := mod.loadSlice(sp + 16)
@@ -1459,7 +2063,7 @@ func (mod *Module) CopyBytesToJS(sp uint32) {
 			return err
 		}
 
-		n := copy(dst.data, src)
+		n := copy(dst, src)
 		if err = mod.instance.SetInt64(sp+40, int64(n)); err != nil {
 			return err
 		}
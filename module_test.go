@@ -0,0 +1,257 @@
+package wasmexec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testInstance is an Instance without a guest, for testing the parts of a
+// Module that the host uses directly.
+type testInstance struct {
+	Memory
+}
+
+// GetSP implements Instance.
+func (*testInstance) GetSP() (uint32, error) {
+	return 0, nil
+}
+
+// Resume implements Instance.
+func (*testInstance) Resume() error {
+	return nil
+}
+
+// newTestModule returns a Module for a testInstance.
+func newTestModule() *Module {
+	return New(&testInstance{Memory: NewMemory(make([]byte, 64*1024))})
+}
+
+// runInstance is a testInstance with a run export, which calls start with the
+// guest locked, and resume whenever the guest is resumed for a timeout event.
+type runInstance struct {
+	testInstance
+	mod    *Module
+	start  func(mod *Module)
+	resume func(mod *Module)
+}
+
+// Run implements runner.
+func (instance *runInstance) Run(argc, argv int32) error {
+	instance.start(instance.mod)
+	return nil
+}
+
+// Resume implements Instance.
+func (instance *runInstance) Resume() error {
+	// The guest clears the timeout events that it handles.
+	instance.mod.clearTimeouts()
+	instance.resume(instance.mod)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	exit := func(mod *Module) {
+		mod.exitCode = 3
+		mod.stop(nil)
+	}
+
+	tests := []struct {
+		name    string
+		start   func(mod *Module)
+		resume  func(mod *Module)
+		timeout time.Duration
+		code    int
+		err     error
+	}{
+		{
+			name:  "exit",
+			start: exit,
+			code:  3,
+		},
+		{
+			name: "exit with code 0",
+			start: func(mod *Module) {
+				mod.stop(nil)
+			},
+		},
+		{
+			name: "exit after a timeout",
+			start: func(mod *Module) {
+				mod.scheduleTimeout(10 * time.Millisecond)
+			},
+			resume: exit,
+			code:   3,
+		},
+		{
+			name:  "idle",
+			start: func(mod *Module) {},
+			err:   ErrIdle,
+		},
+		{
+			name: "idle after a timeout",
+			start: func(mod *Module) {
+				mod.scheduleTimeout(10 * time.Millisecond)
+			},
+			resume: func(mod *Module) {},
+			err:    ErrIdle,
+		},
+		{
+			name: "cancelled",
+			start: func(mod *Module) {
+				mod.scheduleTimeout(time.Hour)
+			},
+			timeout: 10 * time.Millisecond,
+			err:     context.DeadlineExceeded,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &runInstance{
+				testInstance: testInstance{Memory: NewMemory(make([]byte, 64*1024))},
+				start:        test.start,
+				resume:       test.resume,
+			}
+			instance.mod = New(instance)
+
+			timeout := test.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			code, err := instance.mod.Run(ctx, []string{"test"}, nil)
+			switch {
+			case !errors.Is(err, test.err):
+				t.Fatalf("Run: got error %v, want %v", err, test.err)
+			case code != test.code:
+				t.Fatalf("Run: got exit code %d, want %d", code, test.code)
+			}
+		})
+	}
+}
+
+// movingStackInstance is a testInstance whose stack moves whenever the guest
+// is resumed, like the stack of a Go guest can when it grows.
+type movingStackInstance struct {
+	testInstance
+	mod *Module
+	sp  uint32
+}
+
+// GetSP implements Instance.
+func (instance *movingStackInstance) GetSP() (uint32, error) {
+	return instance.sp, nil
+}
+
+// Resume implements Instance.
+func (instance *movingStackInstance) Resume() error {
+	instance.sp += 1024
+
+	// Handle the pending event like the guest does.
+	event := instance.mod.values[6].(*jsObject).properties["_pendingEvent"].(*jsObject)
+	event.properties["result"] = "from the guest"
+	return nil
+}
+
+func TestCallReentersGuest(t *testing.T) {
+	const sp = 1024
+
+	tests := []struct {
+		name string
+		call func(mod *Module, fn *jsFunction)
+		off  uint32
+	}{
+		{
+			name: "call",
+			off:  56,
+			call: func(mod *Module, fn *jsFunction) {
+				name := []byte("method")
+				b, _ := mod.instance.Range(8192, uint32(len(name)))
+				copy(b, name)
+
+				_ = mod.storeValue(sp+8, &jsObject{properties: jsProperties{"method": fn}})
+				_ = mod.instance.SetInt64(sp+16, 8192)
+				_ = mod.instance.SetInt64(sp+24, int64(len(name)))
+				mod.ValueCall(sp)
+			},
+		},
+		{
+			name: "invoke",
+			off:  40,
+			call: func(mod *Module, fn *jsFunction) {
+				_ = mod.storeValue(sp+8, fn)
+				mod.ValueInvoke(sp)
+			},
+		},
+		{
+			name: "new",
+			off:  40,
+			call: func(mod *Module, fn *jsFunction) {
+				_ = mod.storeValue(sp+8, fn)
+				mod.ValueNew(sp)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for _, throw := range []bool{false, true} {
+			name := test.name
+			if throw {
+				name += " that throws"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				instance := &movingStackInstance{
+					testInstance: testInstance{Memory: NewMemory(make([]byte, 64*1024))},
+					sp:           sp,
+				}
+				mod := New(instance)
+				instance.mod = mod
+
+				// The callee calls a function of the guest, which resumes the
+				// guest and moves its stack.
+				makeFuncWrapper := mod.values[6].(*jsObject).properties["_makeFuncWrapper"].(*jsFunction)
+				guestFn := makeFuncWrapper.fn([]any{float64(1)}).(*jsFunction)
+
+				fn := &jsFunction{
+					fn: func(args []any) any {
+						result := guestFn.fn(nil)
+						if throw {
+							return &jsThrow{value: "thrown"}
+						}
+						return result
+					},
+				}
+
+				test.call(mod, fn)
+
+				if instance.sp != sp+1024 {
+					t.Fatalf("the guest was not resumed")
+				}
+
+				want, wantOK := "from the guest", byte(1)
+				if throw {
+					want, wantOK = "thrown", 0
+				}
+
+				result, err := mod.loadValue(instance.sp + test.off)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ok, _ := instance.Range(instance.sp+test.off+8, 1)
+
+				if s := toString(result); s != want || ok[0] != wantOK {
+					t.Errorf("got %q and %d at the stack pointer after the call, want %q and %d", s, ok[0], want, wantOK)
+				}
+				if stale, _ := instance.GetFloat64(sp + test.off); stale != 0 {
+					t.Errorf("a result was stored at the stack pointer before the call")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package wasmexec
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Mounts is a file system that is composed of other file systems, each of
+// them mounted on a directory. A file system that does not implement
+// WritableFS is mounted read-only.
+type Mounts struct {
+	mounts []mountPoint
+}
+
+// mountPoint describes a file system mounted on a directory.
+type mountPoint struct {
+	dir  string
+	fsys fs.FS
+}
+
+// Mount mounts fsys on the directory dir, like "/" or "/data". Mounting a
+// file system on a directory that is already in use replaces it.
+func (m *Mounts) Mount(dir string, fsys fs.FS) {
+	dir = fsName(dir)
+
+	for i := range m.mounts {
+		if m.mounts[i].dir == dir {
+			m.mounts[i].fsys = fsys
+			return
+		}
+	}
+
+	m.mounts = append(m.mounts, mountPoint{dir: dir, fsys: fsys})
+
+	// Keep the longest, and thus deepest, mount points first so they take
+	// precedence over the mount points of their parent directories.
+	sort.SliceStable(m.mounts, func(i, j int) bool {
+		return mountRank(m.mounts[i].dir) > mountRank(m.mounts[j].dir)
+	})
+}
+
+// mountRank returns the rank of a mount point directory when looking up names.
+func mountRank(dir string) int {
+	if dir == "." {
+		return 0
+	}
+
+	return len(dir)
+}
+
+// ReadOnly returns fsys without any of its write methods.
+func ReadOnly(fsys fs.FS) fs.FS {
+	return struct{ fs.FS }{fsys}
+}
+
+// lookup returns the file system on which name is mounted and the name
+// relative to that file system.
+func (m *Mounts) lookup(op, name string) (fs.FS, string, error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, mp := range m.mounts {
+		switch {
+		case mp.dir == ".":
+			return mp.fsys, name, nil
+		case name == mp.dir:
+			return mp.fsys, ".", nil
+		case strings.HasPrefix(name, mp.dir+"/"):
+			return mp.fsys, name[len(mp.dir)+1:], nil
+		}
+	}
+
+	return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// lookupWritable returns the writable file system on which name is mounted
+// and the name relative to that file system.
+func (m *Mounts) lookupWritable(op, name string) (WritableFS, string, error) {
+	fsys, rel, err := m.lookup(op, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	wfs, ok := fsys.(WritableFS)
+	if !ok {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.EROFS}
+	}
+
+	return wfs, rel, nil
+}
+
+// Open implements fs.FS.
+func (m *Mounts) Open(name string) (fs.File, error) {
+	fsys, rel, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsys.Open(rel)
+}
+
+// Stat implements fs.StatFS.
+func (m *Mounts) Stat(name string) (fs.FileInfo, error) {
+	fsys, rel, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Stat(fsys, rel)
+}
+
+// Lstat implements SymlinkFS. On a file system that does not implement
+// SymlinkFS, it is the same as Stat.
+func (m *Mounts) Lstat(name string) (fs.FileInfo, error) {
+	fsys, rel, err := m.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if sfs, ok := fsys.(SymlinkFS); ok {
+		return sfs.Lstat(rel)
+	}
+
+	return fs.Stat(fsys, rel)
+}
+
+// ReadLink implements SymlinkFS. On a file system that does not implement
+// SymlinkFS, no file is a symbolic link.
+func (m *Mounts) ReadLink(name string) (string, error) {
+	fsys, rel, err := m.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	if sfs, ok := fsys.(SymlinkFS); ok {
+		return sfs.ReadLink(rel)
+	}
+
+	if _, err := fs.Stat(fsys, rel); err != nil {
+		return "", err
+	}
+
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+}
+
+// Symlink implements SymlinkFS. The target is stored as is, so an absolute
+// target is resolved by the file system on which the link is mounted.
+func (m *Mounts) Symlink(oldname, newname string) error {
+	fsys, rel, err := m.lookupWritable("symlink", newname)
+	if err != nil {
+		return err
+	}
+
+	sfs, ok := fsys.(SymlinkFS)
+	if !ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: syscall.ENOSYS}
+	}
+
+	return sfs.Symlink(oldname, rel)
+}
+
+// OpenFile implements WritableFS.
+func (m *Mounts) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	fsys, rel, err := m.lookupWritable("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsys.OpenFile(rel, flag, perm)
+}
+
+// Mkdir implements WritableFS.
+func (m *Mounts) Mkdir(name string, perm fs.FileMode) error {
+	fsys, rel, err := m.lookupWritable("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.Mkdir(rel, perm)
+}
+
+// Rename implements WritableFS. Renaming a file to another mount point is
+// not possible.
+func (m *Mounts) Rename(oldname, newname string) error {
+	oldfs, oldrel, err := m.lookupWritable("rename", oldname)
+	if err != nil {
+		return err
+	}
+
+	newfs, newrel, err := m.lookupWritable("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	if oldfs != newfs {
+		return &fs.PathError{Op: "rename", Path: newname, Err: syscall.EXDEV}
+	}
+
+	return oldfs.Rename(oldrel, newrel)
+}
+
+// Remove implements WritableFS. A mount point itself can not be removed.
+func (m *Mounts) Remove(name string) error {
+	fsys, rel, err := m.lookupWritable("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if rel == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+
+	return fsys.Remove(rel)
+}
+
+// Truncate implements WritableFS.
+func (m *Mounts) Truncate(name string, size int64) error {
+	fsys, rel, err := m.lookupWritable("truncate", name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.Truncate(rel, size)
+}
+
+// Chmod implements WritableFS.
+func (m *Mounts) Chmod(name string, mode fs.FileMode) error {
+	fsys, rel, err := m.lookupWritable("chmod", name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.Chmod(rel, mode)
+}
+
+// Chtimes implements WritableFS.
+func (m *Mounts) Chtimes(name string, atime, mtime time.Time) error {
+	fsys, rel, err := m.lookupWritable("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.Chtimes(rel, atime, mtime)
+}
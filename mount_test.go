@@ -0,0 +1,114 @@
+package wasmexec
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMounts(t *testing.T) {
+	data := NewMemFS()
+	tmp := NewMemFS()
+
+	var m Mounts
+	m.Mount("/", fstest.MapFS{
+		"etc/hosts": &fstest.MapFile{Data: []byte("root")},
+		"data/file": &fstest.MapFile{Data: []byte("hidden")},
+	})
+	m.Mount("/data", data)
+	m.Mount("/tmp", NewMemFS())
+	m.Mount("/tmp", tmp)
+
+	if err := m.Mkdir("data/sub", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"data/file":     "data",
+		"data/sub/file": "sub",
+		"tmp/file":      "tmp",
+	} {
+		if err := writeFile(&m, name, contents); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		contents string
+		err      error
+	}{
+		{name: "etc/hosts", contents: "root"},
+		{name: "data/file", contents: "data"},
+		{name: "data/sub/file", contents: "sub"},
+		{name: "tmp/file", contents: "tmp"},
+		{name: "data/../etc/hosts", err: fs.ErrInvalid},
+		{name: "/etc/hosts", err: fs.ErrInvalid},
+		{name: "etc/missing", err: fs.ErrNotExist},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := fs.ReadFile(&m, test.name)
+			switch {
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Fatalf("ReadFile: got %v, want %v", err, test.err)
+			case test.err == nil && err != nil:
+				t.Fatalf("ReadFile: %v", err)
+			case string(b) != test.contents:
+				t.Fatalf("ReadFile: got %q, want %q", b, test.contents)
+			}
+		})
+	}
+
+	// The files are written to the file systems that they are mounted on.
+	if b, err := fs.ReadFile(data, "sub/file"); err != nil || string(b) != "sub" {
+		t.Errorf("data: ReadFile: got %q, %v", b, err)
+	}
+	if b, err := fs.ReadFile(tmp, "file"); err != nil || string(b) != "tmp" {
+		t.Errorf("tmp: ReadFile: got %q, %v", b, err)
+	}
+
+	errs := []struct {
+		op  string
+		err error
+		fn  func() error
+	}{
+		{op: "write read-only", err: syscall.EROFS, fn: func() error { return writeFile(&m, "etc/hosts", "") }},
+		{op: "mkdir read-only", err: syscall.EROFS, fn: func() error { return m.Mkdir("etc/dir", 0o755) }},
+		{op: "rename across", err: syscall.EXDEV, fn: func() error { return m.Rename("data/file", "tmp/file") }},
+		{op: "remove mount point", err: syscall.EBUSY, fn: func() error { return m.Remove("tmp") }},
+	}
+
+	for _, test := range errs {
+		if err := test.fn(); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.op, err, test.err)
+		}
+	}
+
+	if err := m.Rename("data/file", "data/sub/moved"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := data.Stat("sub/moved"); err != nil {
+		t.Errorf("Stat: %v", err)
+	}
+}
+
+// writeFile creates or truncates the file name and writes contents to it.
+func writeFile(fsys WritableFS, name, contents string) error {
+	f, err := fsys.OpenFile(name, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, ok := f.(io.Writer)
+	if !ok {
+		return errors.New("file is not writable")
+	}
+
+	_, err = io.WriteString(w, contents)
+	return err
+}
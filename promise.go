@@ -0,0 +1,445 @@
+package wasmexec
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+)
+
+var promiseType = reflect.TypeOf((*Promise)(nil))
+
+// promiseState describes whether a promise is still pending, or how it was
+// settled.
+type promiseState int
+
+const (
+	promisePending promiseState = iota
+	promiseFulfilled
+	promiseRejected
+)
+
+// Promise is a JavaScript Promise. Promises are created by the guest through
+// the Promise constructor, or by the host through NewPromise, after which they
+// can be passed between the two like any other value.
+type Promise struct {
+	mod *Module
+
+	state  promiseState
+	result any
+
+	// resolved is set to 1 by the first call that resolves or rejects the
+	// promise, after which any other call has no effect. It is accessed
+	// atomically, as the host can settle a promise without the guest locked.
+	// Unlike state, it is set right away, even though the promise may only
+	// settle later on, like when it follows another promise.
+	resolved int32
+
+	// reactions are called once the promise is settled.
+	reactions []func()
+
+	// settled is closed when the promise is settled.
+	settled chan struct{}
+
+	// host is true for a promise that was created by NewPromise and is
+	// counted in pendingPromises until it is settled.
+	host bool
+}
+
+// awaiter describes an Await call that waits for a promise to settle.
+type awaiter struct {
+	promise *Promise
+
+	// deadlock is closed when the guest is unable to settle the promise.
+	deadlock chan struct{}
+}
+
+// NewPromise returns a pending promise that the host settles by calling
+// Resolve or Reject, which is safe to do from any goroutine. Returning it
+// from a Func allows the guest to wait for a slow operation of the host,
+// without blocking the guest in the meantime:
+//
+//	mod.RegisterFunc("fetch", func(args []wasmexec.Value) (any, error) {
+//		p := mod.NewPromise()
+//		url := args[0].String()
+//
+//		go func() {
+//			data, err := fetch(url)
+//			if err != nil {
+//				p.Reject(err)
+//				return
+//			}
+//			p.Resolve(data)
+//		}()
+//
+//		return p, nil
+//	})
+//
+// As long as a promise created by NewPromise is pending, the guest is not
+// considered to be deadlocked, so it must be settled eventually.
+func (mod *Module) NewPromise() *Promise {
+	p := mod.newPromise()
+	p.host = true
+	atomic.AddInt32(&mod.pendingPromises, 1)
+
+	return p
+}
+
+// newPromise returns a pending promise.
+func (mod *Module) newPromise() *Promise {
+	return &Promise{mod: mod, settled: make(chan struct{})}
+}
+
+// Resolve fulfills the promise with v, which is converted like the value
+// passed to SetGlobal. Resolving a promise that is already settled has no
+// effect. The promise is settled in the guest asynchronously, but the first
+// call to Resolve or Reject is the one that determines the outcome.
+func (p *Promise) Resolve(v any) {
+	if !p.claim() {
+		return
+	}
+
+	go func() {
+		value, err := p.mod.toJS(v)
+
+		if p.mod.lock(context.Background()) != nil {
+			return
+		}
+		defer p.mod.unlock()
+
+		if err != nil {
+			p.settle(promiseRejected, p.mod.errorObject(err))
+			return
+		}
+
+		p.follow(value)
+	}()
+}
+
+// Reject rejects the promise with an Error object that holds the message of
+// err. Rejecting a promise that is already settled has no effect. Like with
+// Resolve, the first call to Resolve or Reject determines the outcome.
+func (p *Promise) Reject(err error) {
+	if !p.claim() {
+		return
+	}
+
+	go func() {
+		if p.mod.lock(context.Background()) != nil {
+			return
+		}
+		defer p.mod.unlock()
+
+		p.settle(promiseRejected, p.mod.thrownValue(err))
+	}()
+}
+
+// claim marks the promise as resolved and returns true, or returns false if
+// it was resolved or rejected before.
+func (p *Promise) claim() bool {
+	return atomic.CompareAndSwapInt32(&p.resolved, 0, 1)
+}
+
+// Await waits for the promise to settle. It returns the value the promise was
+// fulfilled with, or an error with the reason it was rejected. This is how the
+// result of a guest function that returns a promise is obtained:
+//
+//	v, err := mod.Call("fetchAll")
+//	if err != nil {
+//		return err
+//	}
+//
+//	if p, ok := v.(*wasmexec.Promise); ok {
+//		v, err = p.Await(ctx)
+//	}
+//
+// If ctx is done first, ctx.Err() is returned. If the guest becomes idle and
+// there is nothing left that could settle the promise, an *ErrGuestDeadlock is
+// returned. Await must not be called from within a Func, as that would
+// deadlock.
+func (p *Promise) Await(ctx context.Context) (any, error) {
+	mod := p.mod
+	a := &awaiter{promise: p, deadlock: make(chan struct{})}
+
+	if err := mod.lock(ctx); err != nil {
+		return nil, err
+	}
+	if p.state == promisePending {
+		mod.awaits[a] = struct{}{}
+	}
+	mod.unlock()
+
+	select {
+	case <-p.settled:
+		if p.state == promiseRejected {
+			return nil, mod.exception(p.result)
+		}
+
+		return p.result, nil
+
+	case <-a.deadlock:
+		return nil, &ErrGuestDeadlock{Operation: "Await"}
+
+	case <-ctx.Done():
+		go func() {
+			if mod.lock(context.Background()) != nil {
+				return
+			}
+			defer mod.unlock()
+
+			delete(mod.awaits, a)
+		}()
+
+		return nil, ctx.Err()
+
+	case <-mod.done:
+		return nil, mod.exitErr()
+	}
+}
+
+// resolve resolves the promise with v, unless it was resolved or rejected
+// before.
+//
+// This method must be called with the guest locked.
+func (p *Promise) resolve(v any) {
+	if p.claim() {
+		p.follow(v)
+	}
+}
+
+// follow settles the promise with v. If v is a promise itself, the promise
+// follows it and settles the same way, once it does.
+//
+// This method must be called with the guest locked.
+func (p *Promise) follow(v any) {
+	other, ok := v.(*Promise)
+	switch {
+	case !ok:
+		p.settle(promiseFulfilled, v)
+	case other == p:
+		p.settle(promiseRejected, p.mod.newError("TypeError", "Chaining cycle detected for promise"))
+	default:
+		other.subscribe(func() {
+			p.settle(other.state, other.result)
+		})
+	}
+}
+
+// reject rejects the promise with reason, unless it was resolved or rejected
+// before.
+//
+// This method must be called with the guest locked.
+func (p *Promise) reject(reason any) {
+	if p.claim() {
+		p.settle(promiseRejected, reason)
+	}
+}
+
+// settle sets the state and result of a pending promise and schedules its
+// reactions.
+//
+// This method must be called with the guest locked.
+func (p *Promise) settle(state promiseState, result any) {
+	if p.state != promisePending {
+		return
+	}
+
+	p.state = state
+	p.result = result
+	close(p.settled)
+
+	if p.host {
+		atomic.AddInt32(&p.mod.pendingPromises, -1)
+	}
+
+	for a := range p.mod.awaits {
+		if a.promise == p {
+			delete(p.mod.awaits, a)
+		}
+	}
+
+	for _, reaction := range p.reactions {
+		p.mod.queueJob(reaction)
+	}
+	p.reactions = nil
+}
+
+// subscribe calls fn once the promise is settled. Like in JavaScript, fn is
+// never called right away, but only after the guest has given back control.
+//
+// This method must be called with the guest locked.
+func (p *Promise) subscribe(fn func()) {
+	if p.state == promisePending {
+		p.reactions = append(p.reactions, fn)
+		return
+	}
+
+	p.mod.queueJob(fn)
+}
+
+// then returns a promise that is resolved with the result of onFulfilled or
+// onRejected, depending on how this promise settles. A handler that is not a
+// function passes the result on as is.
+//
+// This method must be called with the guest locked.
+func (p *Promise) then(onFulfilled, onRejected any) *Promise {
+	next := p.mod.newPromise()
+
+	p.subscribe(func() {
+		handler := onFulfilled
+		if p.state == promiseRejected {
+			handler = onRejected
+		}
+
+		fn, ok := handler.(*jsFunction)
+		if !ok {
+			if next.claim() {
+				next.settle(p.state, p.result)
+			}
+			return
+		}
+
+		result := fn.fn([]any{p.result})
+		if t, ok := result.(*jsThrow); ok {
+			next.reject(t.value)
+			return
+		}
+
+		next.resolve(result)
+	})
+
+	return next
+}
+
+// finally returns a promise that settles like this promise, after calling
+// onFinally without any arguments. If onFinally throws, the returned promise
+// is rejected instead.
+//
+// This method must be called with the guest locked.
+func (p *Promise) finally(onFinally any) *Promise {
+	next := p.mod.newPromise()
+
+	p.subscribe(func() {
+		if fn, ok := onFinally.(*jsFunction); ok {
+			if t, ok := fn.fn(nil).(*jsThrow); ok {
+				next.reject(t.value)
+				return
+			}
+		}
+
+		if next.claim() {
+			next.settle(p.state, p.result)
+		}
+	})
+
+	return next
+}
+
+// get returns the property name of the promise, which are its methods.
+func (p *Promise) get(name string) any {
+	switch name {
+	case "then":
+		return &jsFunction{
+			fn: func(args []any) any {
+				return p.then(arg(args, 0), arg(args, 1))
+			},
+		}
+	case "catch":
+		return &jsFunction{
+			fn: func(args []any) any {
+				return p.then(nil, arg(args, 0))
+			},
+		}
+	case "finally":
+		return &jsFunction{
+			fn: func(args []any) any {
+				return p.finally(arg(args, 0))
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// arg returns the argument at index i, or undefined if there is none.
+func arg(args []any, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+
+	return nil
+}
+
+// promiseConstructor returns the Promise constructor of the global object,
+// along with its static methods resolve and reject. The executor passed to it
+// is called right away with the functions that resolve and reject the new
+// promise.
+func (mod *Module) promiseConstructor() *jsFunction {
+	return &jsFunction{
+		name: "Promise",
+		properties: jsProperties{
+			"resolve": &jsFunction{
+				fn: func(args []any) any {
+					if p, ok := arg(args, 0).(*Promise); ok {
+						return p
+					}
+
+					p := mod.newPromise()
+					p.resolve(arg(args, 0))
+					return p
+				},
+			},
+			"reject": &jsFunction{
+				fn: func(args []any) any {
+					p := mod.newPromise()
+					p.reject(arg(args, 0))
+					return p
+				},
+			},
+		},
+		fn: func(args []any) any {
+			executor, ok := arg(args, 0).(*jsFunction)
+			if !ok {
+				return &jsThrow{value: mod.newError("TypeError", "Promise resolver is not a function")}
+			}
+
+			p := mod.newPromise()
+			resolve := &jsFunction{
+				fn: func(args []any) any {
+					p.resolve(arg(args, 0))
+					return nil
+				},
+			}
+			reject := &jsFunction{
+				fn: func(args []any) any {
+					p.reject(arg(args, 0))
+					return nil
+				},
+			}
+
+			if t, ok := executor.fn([]any{resolve, reject}).(*jsThrow); ok {
+				p.reject(t.value)
+			}
+
+			return p
+		},
+	}
+}
+
+// queueJob queues fn to run once the guest has given back control.
+//
+// This method must be called with the guest locked.
+func (mod *Module) queueJob(fn func()) {
+	mod.jobs = append(mod.jobs, fn)
+}
+
+// runJobs runs the queued jobs, including the ones that are queued while
+// doing so.
+//
+// This method must be called with the guest locked.
+func (mod *Module) runJobs() {
+	for len(mod.jobs) > 0 && !mod.exited() {
+		fn := mod.jobs[0]
+		mod.jobs = mod.jobs[1:]
+		fn()
+	}
+}
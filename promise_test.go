@@ -0,0 +1,179 @@
+package wasmexec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromiseSettlement(t *testing.T) {
+	errReason := errors.New("reason")
+
+	tests := []struct {
+		name   string
+		settle func(mod *Module, p *Promise)
+		result any
+		err    string
+	}{
+		{
+			name: "resolve",
+			settle: func(mod *Module, p *Promise) {
+				p.Resolve(1)
+			},
+			result: float64(1),
+		},
+		{
+			name: "reject",
+			settle: func(mod *Module, p *Promise) {
+				p.Reject(errReason)
+			},
+			err: "Error: reason",
+		},
+		{
+			name: "resolve then reject",
+			settle: func(mod *Module, p *Promise) {
+				p.Resolve(1)
+				p.Reject(errReason)
+			},
+			result: float64(1),
+		},
+		{
+			name: "reject then resolve",
+			settle: func(mod *Module, p *Promise) {
+				p.Reject(errReason)
+				p.Resolve(1)
+			},
+			err: "Error: reason",
+		},
+		{
+			name: "resolve twice",
+			settle: func(mod *Module, p *Promise) {
+				p.Resolve(1)
+				p.Resolve(2)
+			},
+			result: float64(1),
+		},
+		{
+			name: "resolve with a promise",
+			settle: func(mod *Module, p *Promise) {
+				other := mod.NewPromise()
+				p.Resolve(other)
+				p.Reject(errReason)
+				other.Resolve("other")
+			},
+			result: "other",
+		},
+		{
+			name: "resolve in the guest first",
+			settle: func(mod *Module, p *Promise) {
+				withGuest(t, mod, func() {
+					p.resolve("guest")
+				})
+				p.Resolve("host")
+			},
+			result: "guest",
+		},
+		{
+			name: "resolve with a pending promise in the guest",
+			settle: func(mod *Module, p *Promise) {
+				other := mod.NewPromise()
+				withGuest(t, mod, func() {
+					p.resolve(other)
+					p.reject("guest")
+				})
+				p.Reject(errReason)
+				other.Resolve("other")
+			},
+			result: "other",
+		},
+		{
+			name: "chaining cycle",
+			settle: func(mod *Module, p *Promise) {
+				withGuest(t, mod, func() {
+					p.resolve(p)
+				})
+			},
+			err: "TypeError: Chaining cycle detected for promise",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Settling the promise is asynchronous, so repeat the test to
+			// catch any ordering issues.
+			for i := 0; i < 100; i++ {
+				mod := newTestModule()
+				p := mod.NewPromise()
+				test.settle(mod, p)
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				result, err := p.Await(ctx)
+				cancel()
+
+				switch {
+				case test.err == "" && err != nil:
+					t.Fatalf("Await: %v", err)
+				case test.err != "" && (err == nil || err.Error() != test.err):
+					t.Fatalf("Await: got error %v, want %q", err, test.err)
+				case result != test.result:
+					t.Fatalf("Await: got %v, want %v", result, test.result)
+				}
+			}
+		})
+	}
+}
+
+func TestPromiseThen(t *testing.T) {
+	mod := newTestModule()
+	p := mod.NewPromise()
+
+	double := &jsFunction{
+		fn: func(args []any) any {
+			return toNumber(arg(args, 0)) * 2
+		},
+	}
+	throw := &jsFunction{
+		fn: func(args []any) any {
+			return &jsThrow{value: "thrown"}
+		},
+	}
+	handle := &jsFunction{
+		fn: func(args []any) any {
+			return "recovered from " + toString(arg(args, 0))
+		},
+	}
+
+	var doubled, thrown, recovered *Promise
+	withGuest(t, mod, func() {
+		doubled = p.then(double, nil)
+		thrown = doubled.then(throw, nil)
+		recovered = thrown.then(nil, handle)
+	})
+	p.Resolve(21)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if v, err := doubled.Await(ctx); err != nil || v != float64(42) {
+		t.Errorf("doubled: got %v, %v", v, err)
+	}
+	if v, err := thrown.Await(ctx); err == nil || err.Error() != "thrown" {
+		t.Errorf("thrown: got %v, %v", v, err)
+	}
+	if v, err := recovered.Await(ctx); err != nil || v != "recovered from thrown" {
+		t.Errorf("recovered: got %v, %v", v, err)
+	}
+}
+
+// withGuest calls fn with the guest of mod locked.
+func withGuest(t *testing.T, mod *Module, fn func()) {
+	t.Helper()
+
+	if err := mod.lock(context.Background()); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	defer mod.unlock()
+
+	fn()
+}
@@ -0,0 +1,153 @@
+package wasmexec
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// timeout describes a pending timeout event.
+type timeout struct {
+	// timer fires the timeout event in real time. It is nil in virtual time.
+	timer *time.Timer
+
+	// deadline is the time on the virtual clock at which the timeout event
+	// fires in virtual time.
+	deadline int64
+}
+
+// scheduleTimeout schedules a timeout event that resumes the guest after the
+// specified delay. It returns the ID of the timeout event.
+//
+// This method must be called while executing the guest.
+func (mod *Module) scheduleTimeout(delay time.Duration) int32 {
+	id := mod.nextTimeoutID
+	mod.nextTimeoutID++
+
+	if mod.virtual != nil {
+		mod.timeouts[id] = &timeout{deadline: mod.virtual.Nanotime() + int64(delay)}
+	} else {
+		mod.timeouts[id] = &timeout{
+			timer: time.AfterFunc(delay, func() {
+				if err := mod.lock(context.Background()); err != nil {
+					return
+				}
+				defer mod.unlock()
+
+				mod.fireTimeout(id)
+			}),
+		}
+	}
+
+	mod.debug("   scheduleTimeout(id=%v delay=%v)", id, delay)
+
+	return id
+}
+
+// clearTimeout removes a pending timeout event.
+//
+// This method must be called while executing the guest.
+func (mod *Module) clearTimeout(id int32) {
+	mod.debug("   clearTimeout(id=%v)", id)
+
+	if t, ok := mod.timeouts[id]; ok {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		delete(mod.timeouts, id)
+	}
+}
+
+// clearTimeouts removes all pending timeout events.
+func (mod *Module) clearTimeouts() {
+	for id := range mod.timeouts {
+		mod.clearTimeout(id)
+	}
+}
+
+// fireTimeout resumes the guest for the timeout event with the specified ID.
+// The guest is expected to clear the timeout event while handling it.
+//
+// This method must be called with the guest locked.
+func (mod *Module) fireTimeout(id int32) {
+	for {
+		// Skip timeout events that were cleared in the meantime.
+		if _, ok := mod.timeouts[id]; !ok {
+			return
+		}
+
+		if err := mod.resume(); err != nil {
+			mod.error("fireTimeout: Resume: %v", err)
+			mod.clearTimeout(id)
+			return
+		}
+
+		// This mirrors the workaround in wasm_exec.js for
+		// https://github.com/golang/go/issues/28975.
+		if _, ok := mod.timeouts[id]; ok {
+			mod.error("fireTimeout: %d: missed timeout event", id)
+		}
+	}
+}
+
+// nextTimeout returns the ID of the pending timeout event with the earliest
+// deadline, or false if there are no pending timeout events.
+//
+// This method must be called with the guest locked.
+func (mod *Module) nextTimeout() (int32, bool) {
+	var next int32
+	for id, t := range mod.timeouts {
+		if n, ok := mod.timeouts[next]; !ok || t.deadline < n.deadline || t.deadline == n.deadline && id < next {
+			next = id
+		}
+	}
+
+	return next, next != 0
+}
+
+// canAdvanceTime returns true if the virtual clock can jump forward to the
+// next timeout event. That is the case if the guest is idle and there is
+// nothing else that could resume it, while the host is waiting for it through
+// Run, an Invoke call or an Await call.
+//
+// This method must be called with the guest locked.
+func (mod *Module) canAdvanceTime() bool {
+	return mod.virtual != nil && !mod.exited() && len(mod.timeouts) > 0 && mod.pendingReads == 0 &&
+		atomic.LoadInt32(&mod.pendingPromises) == 0 && (mod.running || len(mod.invokes) > 0 || len(mod.awaits) > 0)
+}
+
+// advanceTime fires the next timeout event in virtual time if the virtual
+// clock can jump forward. The timeout event fires in the background, so that
+// the host gets a chance to interact with the guest in between timeout
+// events.
+//
+// This method must be called with the guest locked.
+func (mod *Module) advanceTime() {
+	if mod.advancing || !mod.canAdvanceTime() {
+		return
+	}
+
+	mod.advancing = true
+
+	go func() {
+		if err := mod.lock(context.Background()); err != nil {
+			return
+		}
+		defer mod.unlock()
+
+		mod.advancing = false
+
+		// The host could have interacted with the guest in the meantime.
+		if !mod.canAdvanceTime() {
+			return
+		}
+
+		id, ok := mod.nextTimeout()
+		if !ok {
+			return
+		}
+
+		mod.virtual.advanceTo(mod.timeouts[id].deadline)
+		mod.fireTimeout(id)
+	}()
+}
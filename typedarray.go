@@ -0,0 +1,569 @@
+package wasmexec
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// jsArrayBuffer describes a fixed-length buffer of bytes, of which typed
+// arrays and data views are views. Views of the same buffer share its memory.
+type jsArrayBuffer struct {
+	data []byte
+}
+
+// typedArrayKind describes the type of the elements of a typed array.
+type typedArrayKind struct {
+	name string
+	size int
+	get  func(b []byte) float64
+	set  func(b []byte, f float64)
+}
+
+// typedArrayKinds holds the kinds of typed arrays that the global object has
+// constructors for. The elements are stored in little-endian byte order, like
+// on any platform that runs WebAssembly.
+var typedArrayKinds = []*typedArrayKind{
+	uint8Kind,
+	{
+		name: "Int8Array",
+		size: 1,
+		get:  func(b []byte) float64 { return float64(int8(b[0])) },
+		set:  func(b []byte, f float64) { b[0] = uint8(toUint32(f)) },
+	},
+	{
+		name: "Uint8ClampedArray",
+		size: 1,
+		get:  func(b []byte) float64 { return float64(b[0]) },
+		set: func(b []byte, f float64) {
+			switch {
+			case math.IsNaN(f) || f <= 0:
+				b[0] = 0
+			case f >= 255:
+				b[0] = 255
+			default:
+				b[0] = uint8(math.RoundToEven(f))
+			}
+		},
+	},
+	{
+		name: "Int16Array",
+		size: 2,
+		get:  func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) },
+		set:  func(b []byte, f float64) { binary.LittleEndian.PutUint16(b, uint16(toUint32(f))) },
+	},
+	{
+		name: "Uint16Array",
+		size: 2,
+		get:  func(b []byte) float64 { return float64(binary.LittleEndian.Uint16(b)) },
+		set:  func(b []byte, f float64) { binary.LittleEndian.PutUint16(b, uint16(toUint32(f))) },
+	},
+	{
+		name: "Int32Array",
+		size: 4,
+		get:  func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) },
+		set:  func(b []byte, f float64) { binary.LittleEndian.PutUint32(b, toUint32(f)) },
+	},
+	{
+		name: "Uint32Array",
+		size: 4,
+		get:  func(b []byte) float64 { return float64(binary.LittleEndian.Uint32(b)) },
+		set:  func(b []byte, f float64) { binary.LittleEndian.PutUint32(b, toUint32(f)) },
+	},
+	{
+		name: "Float32Array",
+		size: 4,
+		get:  func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) },
+		set:  func(b []byte, f float64) { binary.LittleEndian.PutUint32(b, math.Float32bits(float32(f))) },
+	},
+	{
+		name: "Float64Array",
+		size: 8,
+		get:  func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) },
+		set:  func(b []byte, f float64) { binary.LittleEndian.PutUint64(b, math.Float64bits(f)) },
+	},
+}
+
+// uint8Kind is the kind of a Uint8Array, which is stored as a jsUint8Array
+// rather than as a jsTypedArray.
+var uint8Kind = &typedArrayKind{
+	name: "Uint8Array",
+	size: 1,
+	get:  func(b []byte) float64 { return float64(b[0]) },
+	set:  func(b []byte, f float64) { b[0] = uint8(toUint32(f)) },
+}
+
+// toUint32 converts f to an integer modulo 2^32, like JavaScript does when it
+// stores a number in an integer typed array. NaN and infinity become 0.
+func toUint32(f float64) uint32 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+
+	f = math.Mod(math.Trunc(f), 1<<32)
+	if f < 0 {
+		f += 1 << 32
+	}
+
+	return uint32(f)
+}
+
+// typedArray is implemented by the typed arrays.
+type typedArray interface {
+	// kind returns the type of the elements.
+	kind() *typedArrayKind
+
+	// view returns the buffer, the offset in it in bytes and the number of
+	// elements of the typed array.
+	view() (buffer *jsArrayBuffer, offset, length int)
+
+	// bytes returns the memory of the typed array.
+	bytes() []byte
+}
+
+// jsTypedArray describes a typed array other than a Uint8Array.
+type jsTypedArray struct {
+	elemKind *typedArrayKind
+	buffer   *jsArrayBuffer
+	offset   int
+	length   int
+}
+
+func (a *jsTypedArray) kind() *typedArrayKind {
+	return a.elemKind
+}
+
+func (a *jsTypedArray) view() (*jsArrayBuffer, int, int) {
+	return a.buffer, a.offset, a.length
+}
+
+func (a *jsTypedArray) bytes() []byte {
+	return a.buffer.data[a.offset : a.offset+a.length*a.elemKind.size]
+}
+
+func (a *jsUint8Array) kind() *typedArrayKind {
+	return uint8Kind
+}
+
+// view returns the buffer of the Uint8Array, which is created if the
+// Uint8Array was created from a []byte.
+func (a *jsUint8Array) view() (*jsArrayBuffer, int, int) {
+	if a.buffer == nil {
+		a.buffer = &jsArrayBuffer{data: a.data}
+	}
+
+	return a.buffer, a.offset, len(a.data)
+}
+
+func (a *jsUint8Array) bytes() []byte {
+	return a.data
+}
+
+// jsDataView describes a DataView, which reads and writes numbers of any type
+// at any offset of a buffer.
+type jsDataView struct {
+	buffer *jsArrayBuffer
+	offset int
+	length int
+}
+
+// newTypedArray returns a typed array of the specified kind that is a view of
+// length elements of buffer, starting at offset.
+func newTypedArray(kind *typedArrayKind, buffer *jsArrayBuffer, offset, length int) typedArray {
+	if kind == uint8Kind {
+		return &jsUint8Array{
+			data:   buffer.data[offset : offset+length : offset+length],
+			buffer: buffer,
+			offset: offset,
+		}
+	}
+
+	return &jsTypedArray{elemKind: kind, buffer: buffer, offset: offset, length: length}
+}
+
+// byteArray returns the memory of a Uint8Array or a Uint8ClampedArray, which
+// are the typed arrays that bytes can be copied from and to by the guest.
+func byteArray(v any) ([]byte, bool) {
+	switch a := v.(type) {
+	case *jsUint8Array:
+		return a.data, true
+	case *jsTypedArray:
+		if a.elemKind.name == "Uint8ClampedArray" {
+			return a.bytes(), true
+		}
+	}
+
+	return nil, false
+}
+
+// typedArrayLength returns the number of elements of a typed array.
+func typedArrayLength(a typedArray) int {
+	_, _, length := a.view()
+	return length
+}
+
+// typedArrayIndex returns the element at index i of a typed array.
+func typedArrayIndex(a typedArray, i int) (float64, bool) {
+	if i < 0 || i >= typedArrayLength(a) {
+		return 0, false
+	}
+
+	size := a.kind().size
+	return a.kind().get(a.bytes()[i*size:]), true
+}
+
+// typedArraySetIndex sets the element at index i of a typed array to v, which
+// is converted to a number first. Like in JavaScript, setting an element
+// outside of the typed array has no effect.
+func typedArraySetIndex(a typedArray, i int, v any) {
+	if i < 0 || i >= typedArrayLength(a) {
+		return
+	}
+
+	size := a.kind().size
+	a.kind().set(a.bytes()[i*size:], toNumber(v))
+}
+
+// typedArrayConstructors returns the constructors of the typed arrays,
+// ArrayBuffer and DataView, indexed by their name.
+func (mod *Module) typedArrayConstructors() map[string]*jsFunction {
+	constructors := map[string]*jsFunction{
+		"ArrayBuffer": {
+			name: "ArrayBuffer",
+			fn: func(args []any) any {
+				length, ok := arrayLength(arg(args, 0))
+				if !ok {
+					return &jsThrow{value: mod.newError("RangeError", "Invalid array buffer length")}
+				}
+
+				return &jsArrayBuffer{data: make([]byte, length)}
+			},
+		},
+		"DataView": {
+			name: "DataView",
+			fn: func(args []any) any {
+				buffer, ok := arg(args, 0).(*jsArrayBuffer)
+				if !ok {
+					return &jsThrow{value: mod.newError("TypeError", "First argument to DataView constructor must be an ArrayBuffer")}
+				}
+
+				offset, length, ok := viewBounds(buffer, 1, args)
+				if !ok {
+					return &jsThrow{value: mod.newError("RangeError", "Invalid DataView length")}
+				}
+
+				return &jsDataView{buffer: buffer, offset: offset, length: length}
+			},
+		},
+	}
+
+	for _, kind := range typedArrayKinds {
+		kind := kind
+		constructors[kind.name] = &jsFunction{
+			name: kind.name,
+			properties: jsProperties{
+				"BYTES_PER_ELEMENT": float64(kind.size),
+			},
+			fn: func(args []any) any {
+				a, err := mod.newTypedArrayFrom(kind, args)
+				if err != nil {
+					return &jsThrow{value: mod.errorObject(err)}
+				}
+
+				return a
+			},
+		}
+	}
+
+	return constructors
+}
+
+// newTypedArrayFrom returns a typed array of the specified kind for the
+// arguments passed to its constructor, which are either a length, an
+// ArrayBuffer with an optional byte offset and length, or an array or typed
+// array to copy the elements of.
+func (mod *Module) newTypedArrayFrom(kind *typedArrayKind, args []any) (typedArray, error) {
+	var elements []any
+	switch v := normalize(arg(args, 0)).(type) {
+	case nil, float64:
+		length, ok := arrayLength(v)
+		if !ok {
+			return nil, &Error{Name: "RangeError", Message: "Invalid typed array length"}
+		}
+
+		return newTypedArray(kind, &jsArrayBuffer{data: make([]byte, length*kind.size)}, 0, length), nil
+
+	case *jsArrayBuffer:
+		if len(args) > 1 {
+			if offset, ok := arrayLength(args[1]); !ok || offset%kind.size != 0 {
+				return nil, &Error{Name: "RangeError", Message: "start offset of " + kind.name + " should be a multiple of " + strconv.Itoa(kind.size)}
+			}
+		}
+		if len(args) > 2 && args[2] != nil {
+			length, ok := arrayLength(args[2])
+			if !ok {
+				return nil, &Error{Name: "RangeError", Message: "Invalid typed array length"}
+			}
+			args = []any{args[0], arg(args, 1), float64(length * kind.size)}
+		}
+
+		offset, byteLength, ok := viewBounds(v, 1, args)
+		if !ok || byteLength%kind.size != 0 {
+			return nil, &Error{Name: "RangeError", Message: "Invalid typed array length"}
+		}
+
+		return newTypedArray(kind, v, offset, byteLength/kind.size), nil
+
+	case *jsArray:
+		elements = v.elements
+
+	case typedArray:
+		elements = make([]any, typedArrayLength(v))
+		for i := range elements {
+			elements[i], _ = typedArrayIndex(v, i)
+		}
+
+	default:
+		return nil, typeError("%s: can not create a %s", mod.value(v).Type(), kind.name)
+	}
+
+	a := newTypedArray(kind, &jsArrayBuffer{data: make([]byte, len(elements)*kind.size)}, 0, len(elements))
+	for i, element := range elements {
+		typedArraySetIndex(a, i, element)
+	}
+
+	return a, nil
+}
+
+// arrayLength returns v as the length of an array, which is 0 for undefined.
+func arrayLength(v any) (int, bool) {
+	switch f := normalize(v).(type) {
+	case nil:
+		return 0, true
+	case float64:
+		if f < 0 || f != math.Trunc(f) || f > math.MaxInt32 {
+			return 0, false
+		}
+		return int(f), true
+	default:
+		return 0, false
+	}
+}
+
+// viewBounds returns the byte offset and length of a view of buffer, which are
+// taken from the arguments starting at index i. If they are missing, the view
+// covers the remainder of the buffer.
+func viewBounds(buffer *jsArrayBuffer, i int, args []any) (int, int, bool) {
+	offset, ok := arrayLength(arg(args, i))
+	if !ok || offset > len(buffer.data) {
+		return 0, 0, false
+	}
+
+	length := len(buffer.data) - offset
+	if v := arg(args, i+1); v != nil {
+		if length, ok = arrayLength(v); !ok || offset+length > len(buffer.data) {
+			return 0, 0, false
+		}
+	}
+
+	return offset, length, true
+}
+
+// typedArrayGet returns the property name of a typed array.
+func (mod *Module) typedArrayGet(a typedArray, name string) any {
+	buffer, offset, length := a.view()
+	kind := a.kind()
+
+	switch name {
+	case "length":
+		return float64(length)
+	case "byteLength":
+		return float64(length * kind.size)
+	case "byteOffset":
+		return float64(offset)
+	case "buffer":
+		return buffer
+	case "BYTES_PER_ELEMENT":
+		return float64(kind.size)
+
+	case "subarray":
+		return &jsFunction{
+			fn: func(args []any) any {
+				begin, end := relativeRange(args, length)
+				return newTypedArray(kind, buffer, offset+begin*kind.size, end-begin)
+			},
+		}
+
+	case "slice":
+		return &jsFunction{
+			fn: func(args []any) any {
+				begin, end := relativeRange(args, length)
+				data := make([]byte, (end-begin)*kind.size)
+				copy(data, a.bytes()[begin*kind.size:])
+				return newTypedArray(kind, &jsArrayBuffer{data: data}, 0, end-begin)
+			},
+		}
+
+	case "set":
+		return &jsFunction{
+			fn: func(args []any) any {
+				// Only an array or a typed array can be the source, which is
+				// copied first as it may share its buffer with a.
+				switch normalize(arg(args, 0)).(type) {
+				case *jsArray, typedArray:
+				default:
+					return &jsThrow{value: mod.newError("TypeError", "source is not an array or typed array")}
+				}
+
+				src, err := mod.newTypedArrayFrom(kind, []any{arg(args, 0)})
+				if err != nil {
+					return &jsThrow{value: mod.errorObject(err)}
+				}
+
+				at := toIntegerOrInfinity(arg(args, 1))
+				if at < 0 || at+float64(typedArrayLength(src)) > float64(length) {
+					return &jsThrow{value: mod.newError("RangeError", "offset is out of bounds")}
+				}
+
+				copy(a.bytes()[int(at)*kind.size:], src.bytes())
+				return nil
+			},
+		}
+
+	case "fill":
+		return &jsFunction{
+			fn: func(args []any) any {
+				var bounds []any
+				if len(args) > 1 {
+					bounds = args[1:]
+				}
+
+				begin, end := relativeRange(bounds, length)
+				for i := begin; i < end; i++ {
+					typedArraySetIndex(a, i, arg(args, 0))
+				}
+				return a
+			},
+		}
+
+	default:
+		return nil
+	}
+}
+
+// relativeRange returns the range of elements described by the optional begin
+// and end arguments of methods like subarray and slice, which count from the
+// end if they are negative.
+func relativeRange(args []any, length int) (int, int) {
+	index := func(v any, def int) int {
+		f, ok := normalize(v).(float64)
+		if !ok || math.IsNaN(f) {
+			return def
+		}
+
+		f = math.Trunc(f)
+		if f < 0 {
+			f += float64(length)
+		}
+
+		return int(math.Max(0, math.Min(f, float64(length))))
+	}
+
+	begin := index(arg(args, 0), 0)
+	end := index(arg(args, 1), length)
+	if end < begin {
+		end = begin
+	}
+
+	return begin, end
+}
+
+// get returns the property name of an ArrayBuffer.
+func (buffer *jsArrayBuffer) get(name string) any {
+	switch name {
+	case "byteLength":
+		return float64(len(buffer.data))
+
+	case "slice":
+		return &jsFunction{
+			fn: func(args []any) any {
+				begin, end := relativeRange(args, len(buffer.data))
+				return &jsArrayBuffer{data: append([]byte(nil), buffer.data[begin:end]...)}
+			},
+		}
+
+	default:
+		return nil
+	}
+}
+
+// get returns the property name of a DataView.
+func (dv *jsDataView) get(mod *Module, name string) any {
+	switch name {
+	case "buffer":
+		return dv.buffer
+	case "byteLength":
+		return float64(dv.length)
+	case "byteOffset":
+		return float64(dv.offset)
+	}
+
+	// The methods are named after the typed arrays, like getFloat32 for
+	// Float32Array.
+	if len(name) < 3 || (name[:3] != "get" && name[:3] != "set") {
+		return nil
+	}
+
+	var kind *typedArrayKind
+	for _, k := range typedArrayKinds {
+		if k.name == name[3:]+"Array" && k.name != "Uint8ClampedArray" {
+			kind = k
+		}
+	}
+	if kind == nil {
+		return nil
+	}
+
+	setter := name[:3] == "set"
+
+	return &jsFunction{
+		fn: func(args []any) any {
+			at, ok := arrayLength(arg(args, 0))
+			if !ok || at+kind.size > dv.length {
+				return &jsThrow{value: mod.newError("RangeError", "Offset is outside the bounds of the DataView")}
+			}
+
+			littleEndian := arg(args, 1)
+			if setter {
+				littleEndian = arg(args, 2)
+			}
+
+			// The kinds store their elements in little-endian byte order, so
+			// big-endian values are reversed around them.
+			b := dv.buffer.data[dv.offset+at : dv.offset+at+kind.size]
+			tmp := append([]byte(nil), b...)
+			if littleEndian != true {
+				reverse(tmp)
+			}
+
+			if !setter {
+				return kind.get(tmp)
+			}
+
+			kind.set(tmp, toNumber(arg(args, 1)))
+			if littleEndian != true {
+				reverse(tmp)
+			}
+			copy(b, tmp)
+
+			return nil
+		},
+	}
+}
+
+// reverse reverses the order of the bytes in b.
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
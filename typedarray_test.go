@@ -0,0 +1,55 @@
+package wasmexec
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTypedArraySet(t *testing.T) {
+	mod := newTestModule()
+
+	tests := []struct {
+		name string
+		args []any
+		want string
+		err  string
+	}{
+		{name: "no arguments", args: nil, err: "TypeError: source is not an array or typed array"},
+		{name: "number", args: []any{float64(5)}, err: "TypeError: source is not an array or typed array"},
+		{name: "string", args: []any{"78"}, err: "TypeError: source is not an array or typed array"},
+		{name: "array", args: []any{&jsArray{elements: []any{float64(7), float64(8)}}}, want: "7,8,3"},
+		{name: "offset", args: []any{&jsArray{elements: []any{float64(9)}}, float64(2)}, want: "1,2,9"},
+		{name: "typed array", args: []any{newTypedArray(typedArrayKinds[0], &jsArrayBuffer{data: []byte{4, 5}}, 0, 2), float64(1)}, want: "1,4,5"},
+		{name: "fractional offset", args: []any{&jsArray{elements: []any{float64(9)}}, 1.5}, want: "1,9,3"},
+		{name: "NaN offset", args: []any{&jsArray{elements: []any{float64(9)}}, math.NaN()}, want: "9,2,3"},
+		{name: "negative offset", args: []any{&jsArray{elements: []any{float64(9)}}, float64(-1)}, err: "RangeError: offset is out of bounds"},
+		{name: "infinite offset", args: []any{&jsArray{elements: []any{float64(9)}}, math.Inf(1)}, err: "RangeError: offset is out of bounds"},
+		{name: "too long", args: []any{&jsArray{elements: []any{float64(7), float64(8)}}, float64(2)}, err: "RangeError: offset is out of bounds"},
+		{name: "out of bounds", args: []any{&jsArray{elements: []any{float64(9)}}, float64(3)}, err: "RangeError: offset is out of bounds"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := mod.newTypedArrayFrom(typedArrayKinds[0], []any{&jsArray{elements: []any{float64(1), float64(2), float64(3)}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			set := mod.typedArrayGet(a, "set").(*jsFunction)
+			result := set.fn(test.args)
+
+			if thrown, ok := result.(*jsThrow); ok {
+				if s := toString(thrown.value); s != test.err {
+					t.Fatalf("set: got %q, want %q", s, test.err)
+				}
+				return
+			}
+			if test.err != "" {
+				t.Fatalf("set: got no error, want %q", test.err)
+			}
+			if s := toString(a); s != test.want {
+				t.Fatalf("set: got %q, want %q", s, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package wasmexec
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Type represents the JavaScript type of a Value.
+type Type int
+
+// These are the types of a Value, as defined by syscall/js.
+const (
+	TypeUndefined Type = iota
+	TypeNull
+	TypeBoolean
+	TypeNumber
+	TypeString
+	TypeSymbol
+	TypeObject
+	TypeFunction
+)
+
+// String returns the name of the type.
+func (t Type) String() string {
+	switch t {
+	case TypeUndefined:
+		return "undefined"
+	case TypeNull:
+		return "null"
+	case TypeBoolean:
+		return "boolean"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeSymbol:
+		return "symbol"
+	case TypeObject:
+		return "object"
+	case TypeFunction:
+		return "function"
+	default:
+		return "Type(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// ValueError is the panic value of a Value method that is called on a value
+// of the wrong type.
+type ValueError struct {
+	Method string
+	Type   Type
+}
+
+// Error implements the error interface.
+func (err *ValueError) Error() string {
+	return "wasmexec: call of " + err.Method + " on " + err.Type.String()
+}
+
+// Value is a JavaScript value that was passed between the guest and the host.
+// A Value that refers to an object is only valid while the guest is locked,
+// like during a call to a function registered with RegisterFunc. The exception
+// are the values obtained through Global, and the values reached from them,
+// which lock the guest whenever they are used. These must not be used from
+// within a Func, as that would deadlock.
+//
+// The host does not tell undefined and null apart, so undefined is a null
+// Value. This also holds where the host converts values like JavaScript does:
+// undefined converts to "null" and 0 like null does, rather than to
+// "undefined" and NaN.
+type Value struct {
+	mod *Module
+	v   any
+
+	// detached is true for a value that was obtained through Global, rather
+	// than while the guest was locked.
+	detached bool
+}
+
+// value returns a Value for a value as it is stored in the guest's objects.
+func (mod *Module) value(v any) Value {
+	return Value{mod: mod, v: normalize(v)}
+}
+
+// derive returns a Value for a value that was reached from v, which is
+// detached if v is.
+func (v Value) derive(x any) Value {
+	return Value{mod: v.mod, v: normalize(x), detached: v.detached}
+}
+
+// use calls fn, with the guest locked if the value is detached. Once the guest
+// has exited, fn is called regardless, as the guest no longer uses its
+// objects.
+func (v Value) use(fn func()) {
+	if v.detached && v.mod.lock(context.Background()) == nil {
+		defer v.mod.unlock()
+	}
+
+	fn()
+}
+
+// normalize converts a value as it is stored in the guest's objects, which
+// could be any kind of number and either type of string, to its canonical
+// form.
+func normalize(v any) any {
+	switch vv := v.(type) {
+	case *jsString:
+		return vv.data
+	case []any:
+		return &jsArray{elements: vv}
+	case []byte:
+		return &jsUint8Array{data: vv}
+	}
+
+	if f, ok := toFloat64(v); ok {
+		return f
+	}
+
+	return v
+}
+
+// toFloat64 converts any Go number to a float64.
+func toFloat64(v any) (float64, bool) {
+	switch num := v.(type) {
+	case float64:
+		return num, true
+	case int:
+		return float64(num), true
+	case uint:
+		return float64(num), true
+	case int8:
+		return float64(num), true
+	case uint8:
+		return float64(num), true
+	case int16:
+		return float64(num), true
+	case uint16:
+		return float64(num), true
+	case int32:
+		return float64(num), true
+	case uint32:
+		return float64(num), true
+	case int64:
+		return float64(num), true
+	case uint64:
+		return float64(num), true
+	case float32:
+		return float64(num), true
+	default:
+		return 0, false
+	}
+}
+
+// Null returns the JavaScript value null.
+func Null() Value {
+	return Value{}
+}
+
+// Type returns the JavaScript type of the value.
+func (v Value) Type() Type {
+	switch v.v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case float64:
+		return TypeNumber
+	case string:
+		return TypeString
+	case *jsFunction:
+		return TypeFunction
+	default:
+		return TypeObject
+	}
+}
+
+// IsNull returns true if the value is null or undefined.
+func (v Value) IsNull() bool {
+	return v.v == nil
+}
+
+// Truthy returns the value converted to a bool, like Boolean(v) in
+// JavaScript. Only false, 0, NaN, the empty string and null are false.
+func (v Value) Truthy() bool {
+	return toBoolean(v.v)
+}
+
+// Bool returns the value as a bool. It panics if the value is not a boolean.
+func (v Value) Bool() bool {
+	b, ok := v.v.(bool)
+	if !ok {
+		panic(&ValueError{Method: "Value.Bool", Type: v.Type()})
+	}
+
+	return b
+}
+
+// Float returns the value as a float64. It panics if the value is not a
+// number.
+func (v Value) Float() float64 {
+	f, ok := v.v.(float64)
+	if !ok {
+		panic(&ValueError{Method: "Value.Float", Type: v.Type()})
+	}
+
+	return f
+}
+
+// Int returns the value truncated to an int. It panics if the value is not a
+// number.
+func (v Value) Int() int {
+	return int(v.Float())
+}
+
+// String returns the value as a string. Unlike the other methods, it does not
+// panic for a value that is not a string, but returns a description of it
+// instead, like syscall/js does.
+func (v Value) String() string {
+	switch vv := v.v.(type) {
+	case string:
+		return vv
+	case nil:
+		return "<null>"
+	case bool:
+		return "<boolean: " + strconv.FormatBool(vv) + ">"
+	case float64:
+		return "<number: " + formatNumber(vv) + ">"
+	default:
+		return fmt.Sprintf("<%s>", v.Type())
+	}
+}
+
+// Bytes returns the memory of a typed array, an ArrayBuffer or a DataView,
+// which is shared with the guest rather than copied. It panics for any other
+// type of value.
+func (v Value) Bytes() []byte {
+	switch vv := v.v.(type) {
+	case typedArray:
+		return vv.bytes()
+	case *jsArrayBuffer:
+		return vv.data
+	case *jsDataView:
+		return vv.buffer.data[vv.offset : vv.offset+vv.length]
+	default:
+		panic(&ValueError{Method: "Value.Bytes", Type: v.Type()})
+	}
+}
+
+// Length returns the length of an array or typed array. It panics for any
+// other type of value.
+func (v Value) Length() int {
+	var length int
+	var ok bool
+	v.use(func() {
+		switch vv := v.v.(type) {
+		case *jsArray:
+			length, ok = len(vv.elements), true
+		case typedArray:
+			length, ok = typedArrayLength(vv), true
+		}
+	})
+	if !ok {
+		panic(&ValueError{Method: "Value.Length", Type: v.Type()})
+	}
+
+	return length
+}
+
+// Index returns the element at index i of an array or typed array. It returns
+// null if i is out of range and panics if the value is not an array.
+func (v Value) Index(i int) Value {
+	var element any
+	var ok bool
+	v.use(func() {
+		switch vv := v.v.(type) {
+		case *jsArray:
+			if i >= 0 && i < len(vv.elements) {
+				element = vv.elements[i]
+			}
+			ok = true
+		case typedArray:
+			if f, exists := typedArrayIndex(vv, i); exists {
+				element = f
+			}
+			ok = true
+		}
+	})
+	if !ok {
+		panic(&ValueError{Method: "Value.Index", Type: v.Type()})
+	}
+
+	return v.derive(element)
+}
+
+// Get returns the property key of an object or function, like the property
+// of the guest's js.Value with the same name. It returns null if the property
+// does not exist and panics if the value is not an object.
+func (v Value) Get(key string) Value {
+	if !v.isObject() {
+		panic(&ValueError{Method: "Value.Get", Type: v.Type()})
+	}
+
+	var property any
+	v.use(func() {
+		property, _ = v.mod.reflectGet(v.v, key)
+	})
+
+	return v.derive(property)
+}
+
+// Set sets the property key of an object to value, which is converted like
+// the value passed to SetGlobal. Setting "length" or an index of an array
+// resizes it as needed. Set panics if the value is not an object.
+func (v Value) Set(key string, value any) error {
+	if !v.isObject() {
+		panic(&ValueError{Method: "Value.Set", Type: v.Type()})
+	}
+
+	x, err := v.mod.toJS(value)
+	if err != nil {
+		return err
+	}
+
+	v.use(func() {
+		err = v.mod.reflectSet(v.v, key, x)
+	})
+
+	return err
+}
+
+// Call calls the method name of an object with the specified arguments, which
+// are converted like the value passed to SetGlobal. The object is passed as
+// the receiver, so a method created by js.FuncOf() gets it as its this
+// argument. An exception thrown by the method is returned as an *Error. Call
+// panics if the value is not an object.
+func (v Value) Call(name string, args ...any) (Value, error) {
+	if !v.isObject() {
+		panic(&ValueError{Method: "Value.Call", Type: v.Type()})
+	}
+
+	var method any
+	v.use(func() {
+		method, _ = v.mod.reflectGet(v.v, name)
+	})
+
+	fn, ok := method.(*jsFunction)
+	if !ok {
+		return Null(), fmt.Errorf("%s: not a function", name)
+	}
+
+	return v.apply(name, fn, v.v, args)
+}
+
+// Invoke calls a function with the specified arguments, which are converted
+// like the value passed to SetGlobal, and undefined as the receiver. An
+// exception thrown by the function is returned as an *Error. Invoke panics if
+// the value is not a function.
+func (v Value) Invoke(args ...any) (Value, error) {
+	fn, ok := v.v.(*jsFunction)
+	if !ok {
+		panic(&ValueError{Method: "Value.Invoke", Type: v.Type()})
+	}
+
+	return v.apply(fn.name, fn, nil, args)
+}
+
+// apply calls fn with this as its receiver, with the guest locked if the
+// value is detached.
+func (v Value) apply(name string, fn *jsFunction, this any, args []any) (Value, error) {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		var err error
+		if values[i], err = v.mod.toJS(arg); err != nil {
+			return Null(), err
+		}
+	}
+
+	call := func() (any, error) {
+		return v.mod.callFunction(name, fn, this, values)
+	}
+
+	var result any
+	var err error
+	if v.detached {
+		result, err = v.mod.enter(context.Background(), call)
+	} else {
+		result, err = call()
+	}
+	if err != nil {
+		return Null(), err
+	}
+
+	return v.derive(result), nil
+}
+
+// isObject returns true if the value is an object or a function.
+func (v Value) isObject() bool {
+	t := v.Type()
+	return t == TypeObject || t == TypeFunction
+}
@@ -3,6 +3,7 @@
 package wapc
 
 import (
+	"context"
 	"errors"
 	"syscall/js"
 )
@@ -21,9 +22,20 @@ type Function func(payload []byte) ([]byte, error)
 
 type Functions map[string]Function
 
-var allFunctions = Functions{}
+// ContextFunction is a Function that receives a context, which is cancelled
+// when the host abandons the call.
+type ContextFunction func(ctx context.Context, payload []byte) ([]byte, error)
+
+var allFunctions = map[string]ContextFunction{}
 
 func RegisterFunction(name string, fn Function) {
+	RegisterContextFunction(name, func(_ context.Context, payload []byte) ([]byte, error) {
+		return fn(payload)
+	})
+}
+
+// RegisterContextFunction registers a ContextFunction for the specified name.
+func RegisterContextFunction(name string, fn ContextFunction) {
 	allFunctions[name] = fn
 }
 
@@ -56,55 +68,90 @@ func HostCall(binding, namespace, operation string, payload []byte) ([]byte, err
 
 func guestCall(_ js.Value, args []js.Value) any {
 	switch {
-	// Make sure there are 2 arguments.
-	case len(args) != 2:
+	// Make sure there are at least 3 arguments.
+	case len(args) < 3:
+		return false
+	// Make sure the 1st one is a number.
+	case args[0].Type() != js.TypeNumber:
 		return false
-	// Make sure the 1st one is a string.
-	case args[0].Type() != js.TypeString:
+	// Make sure the 2nd one is a string.
+	case args[1].Type() != js.TypeString:
 		return false
-	// Make sure the 2nd one is an object.
-	case args[1].Type() != js.TypeObject:
+	// Make sure the 3rd one is an object.
+	case args[2].Type() != js.TypeObject:
 		return false
-	// Make sure the 2nd one is derived from Uint8Array, meaning a []byte.
-	case !args[1].InstanceOf(uint8Array):
+	// Make sure the 3rd one is derived from Uint8Array, meaning a []byte.
+	case !args[2].InstanceOf(uint8Array):
 		return false
 	}
 
+	// Get the correlation ID of this call, which the response needs to carry.
+	id := args[0]
+
 	// Get the operation.
-	operation := args[0].String()
+	operation := args[1].String()
 
 	// Copy the payload over from the host to this guest.
-	payload := bytesFromJS(args[1])
+	payload := bytesFromJS(args[2])
 
 	// Find the function that matches the operation name.
 	fn, ok := allFunctions[operation]
 	if !ok {
-		guestError(`Could not find function "` + operation + `"`)
+		guestError(id, `Could not find function "`+operation+`"`)
 		return false
 	}
 
+	// The optional 4th argument is a signal that the host uses to abort the call.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var signal js.Value
+	var onAbort js.Func
+	if len(args) > 3 && args[3].Type() == js.TypeObject {
+		signal = args[3]
+		onAbort = js.FuncOf(func(js.Value, []js.Value) any {
+			cancel()
+			return nil
+		})
+		signal.Set("onabort", onAbort)
+	}
+
 	// Call the function in a goroutine to allow it to perform non-blocking calls.
 	go func() {
-		response, err := fn(payload)
+		defer func() {
+			if !signal.IsUndefined() {
+				signal.Set("onabort", js.Null())
+				onAbort.Release()
+			}
+
+			cancel()
+		}()
+
+		response, err := fn(ctx, payload)
+
+		// The host is no longer waiting for a response on an aborted call.
+		if ctx.Err() != nil {
+			return
+		}
+
 		if err != nil {
-			guestError(err.Error())
+			guestError(id, err.Error())
 			return
 		}
 
-		guestResponse(response)
+		guestResponse(id, response)
 	}()
 
 	return true
 }
 
-// guestResponse sets the guest response.
-func guestResponse(payload []byte) {
-	jswaPC.Call("__guest_response", bytesToJS(payload))
+// guestResponse sets the guest response for the call with the specified ID.
+func guestResponse(id js.Value, payload []byte) {
+	jswaPC.Call("__guest_response", id, bytesToJS(payload))
 }
 
-// guestError sets the guest error.
-func guestError(message string) {
-	jswaPC.Call("__guest_error", stringToJS(message))
+// guestError sets the guest error for the call with the specified ID.
+func guestError(id js.Value, message string) {
+	jswaPC.Call("__guest_error", id, stringToJS(message))
 }
 
 // bytesFromJS converts a js.Value to a []byte.
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -22,6 +24,7 @@ type Instance struct {
 
 	spFn     wasmer.NativeFunction
 	resumeFn wasmer.NativeFunction
+	runFn    wasmer.NativeFunction
 }
 
 func (instance *Instance) Error(format string, params ...interface{}) {
@@ -47,6 +50,11 @@ func (instance *Instance) Resume() error {
 	return err
 }
 
+func (instance *Instance) Run(argc, argv int32) error {
+	_, err := instance.runFn(argc, argv)
+	return err
+}
+
 func (instance *Instance) Write(fd int, b []byte) (n int, err error) {
 	switch fd {
 	case 1:
@@ -121,23 +129,18 @@ func run(filename string) error {
 		return err
 	}
 
-	args := []string{filename, "-runtime=wasmer", "arg1", "arg2"}
-	envs := []string{"HOME=/", "PWD=/home/test"}
-
-	// Set the args and the environment variables.
-	argc, argv, err := wasmexec.SetArgs(instance.Memory, args, envs)
+	// Fetch the run function and reference it on the instance.
+	instance.runFn, err = instance.Exports.GetFunction("run")
 	if err != nil {
 		return err
 	}
 
-	// Fetch the "run" function and call it. This starts the program.
-	runFn, err := instance.Exports.GetFunction("run")
-	if err != nil {
-		return err
-	}
+	args := []string{filename, "-runtime=wasmer", "arg1", "arg2"}
+	envs := []string{"HOME=/", "PWD=/home/test"}
 
-	_, err = runFn(argc, argv)
-	if err != nil {
+	// Run the program until it exits, or until it is only waiting for calls
+	// from the host, like a waPC guest does.
+	if _, err = gomod.Run(context.Background(), args, envs); err != nil && !errors.Is(err, wasmexec.ErrIdle) {
 		return err
 	}
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -24,6 +25,7 @@ type Instance struct {
 
 	spFn     *wasmtime.Func
 	resumeFn *wasmtime.Func
+	runFn    *wasmtime.Func
 }
 
 func (instance *Instance) Error(format string, params ...interface{}) {
@@ -49,6 +51,11 @@ func (instance *Instance) Resume() error {
 	return err
 }
 
+func (instance *Instance) Run(argc, argv int32) error {
+	_, err := instance.runFn.Call(instance.store, argc, argv)
+	return err
+}
+
 func (instance *Instance) Write(fd int, b []byte) (n int, err error) {
 	switch fd {
 	case 1:
@@ -139,23 +146,17 @@ func run(filename string) error {
 		return errors.New("resume: export is not a function")
 	}
 
-	args := []string{filename, "-runtime=wasmtime", "arg1", "arg2"}
-	envs := []string{"HOME=/", "PWD=/home/test"}
-
-	// Set the args and the environment variables.
-	argc, argv, err := wasmexec.SetArgs(instance.Memory, args, envs)
-	if err != nil {
-		return err
-	}
-
-	// Fetch the "run" function and call it. This starts the program.
-	runFn := instance.GetFunc(store, "run")
-	if runFn == nil {
+	// Fetch the run function and reference it on the instance.
+	if instance.runFn = instance.GetFunc(store, "run"); instance.runFn == nil {
 		return errors.New("run: missing export")
 	}
 
-	_, err = runFn.Call(store, argc, argv)
-	if err != nil {
+	args := []string{filename, "-runtime=wasmtime", "arg1", "arg2"}
+	envs := []string{"HOME=/", "PWD=/home/test"}
+
+	// Run the program until it exits, or until it is only waiting for calls
+	// from the host, like a waPC guest does.
+	if _, err = gomod.Run(context.Background(), args, envs); err != nil && !errors.Is(err, wasmexec.ErrIdle) {
 		return err
 	}
 
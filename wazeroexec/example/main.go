@@ -23,6 +23,7 @@ type Instance struct {
 	wasmexec.Memory
 	spFn     api.Function
 	resumeFn api.Function
+	runFn    api.Function
 }
 
 func (instance *Instance) Error(format string, params ...interface{}) {
@@ -46,6 +47,11 @@ func (instance *Instance) Resume() error {
 	return err
 }
 
+func (instance *Instance) Run(argc, argv int32) error {
+	_, err := instance.runFn.Call(context.Background(), uint64(argc), uint64(argv))
+	return err
+}
+
 func (instance *Instance) Write(fd int, b []byte) (n int, err error) {
 	switch fd {
 	case 1:
@@ -120,23 +126,18 @@ func run(filename string) error {
 		return errors.New("resume: missing export")
 	}
 
-	args := []string{filename, "-runtime=wazero", "arg1", "arg2"}
-	envs := []string{"HOME=/", "PWD=/home/test"}
-
-	// Set the args and the environment variables.
-	argc, argv, err := wasmexec.SetArgs(instance.Memory, args, envs)
-	if err != nil {
-		return err
-	}
-
-	// Fetch the "run" function and call it. This starts the program.
-	runFn := module.ExportedFunction("run")
-	if runFn == nil {
+	// Fetch the run function and reference it on the instance.
+	instance.runFn = module.ExportedFunction("run")
+	if instance.runFn == nil {
 		return errors.New("run: missing export")
 	}
 
-	_, err = runFn.Call(ctx, uint64(argc), uint64(argv))
-	if err != nil {
+	args := []string{filename, "-runtime=wazero", "arg1", "arg2"}
+	envs := []string{"HOME=/", "PWD=/home/test"}
+
+	// Run the program until it exits, or until it is only waiting for calls
+	// from the host, like a waPC guest does.
+	if _, err = gomod.Run(ctx, args, envs); err != nil && !errors.Is(err, wasmexec.ErrIdle) {
 		return err
 	}
 